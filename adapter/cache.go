@@ -0,0 +1,156 @@
+package adapter
+
+import (
+	"container/list"
+	"math/rand"
+	"sync"
+	"time"
+
+	srv "github.com/ethanzeigler/groupme/botserver"
+)
+
+// most quotes loaded into the cache for one person
+const cacheLoadLimit = 1000
+
+// Hit and miss counts of the quote cache
+type CacheStats struct {
+	Hits    uint64
+	Misses  uint64
+	Entries int
+}
+
+// All of one person's quotes in a group
+type cacheEntry struct {
+	groupID string
+	name    string
+	ids     []uint64
+	quotes  map[uint64]Quote
+	expires time.Time
+}
+
+// LRU cache of quotes by group and person so random picks don't
+// need to hit the database
+type quoteCache struct {
+	sync.Mutex
+	maxEntries int
+	ttl        time.Duration
+	lru        *list.List
+	// elements of lru by group ID, then name
+	groups map[string]map[string]*list.Element
+	// bumped by group whenever it's invalidated, so loads that started
+	// before don't put back quotes that were just removed
+	generations map[string]uint64
+	hits        uint64
+	misses      uint64
+}
+
+func newQuoteCache(maxEntries int, ttl time.Duration) *quoteCache {
+	return &quoteCache{
+		maxEntries:  maxEntries,
+		ttl:         ttl,
+		lru:         list.New(),
+		groups:      make(map[string]map[string]*list.Element),
+		generations: make(map[string]uint64),
+	}
+}
+
+func (c *quoteCache) get(groupID string, name string) (*cacheEntry, bool) {
+	c.Lock()
+	defer c.Unlock()
+	elem, ok := c.groups[groupID][name]
+	if ok && time.Now().Before(elem.Value.(*cacheEntry).expires) {
+		c.lru.MoveToFront(elem)
+		c.hits++
+		return elem.Value.(*cacheEntry), true
+	}
+	if ok {
+		c.remove(elem)
+	}
+	c.misses++
+	return nil, false
+}
+
+// Gets the group's generation, to be handed to put once its quotes are loaded
+func (c *quoteCache) generation(groupID string) uint64 {
+	c.Lock()
+	defer c.Unlock()
+	return c.generations[groupID]
+}
+
+// Caches the entry unless its group was invalidated since the generation
+// was taken
+func (c *quoteCache) put(entry *cacheEntry, generation uint64) {
+	c.Lock()
+	defer c.Unlock()
+	if c.generations[entry.groupID] != generation {
+		return
+	}
+	if elem, ok := c.groups[entry.groupID][entry.name]; ok {
+		c.remove(elem)
+	}
+	entry.expires = time.Now().Add(c.ttl)
+	if c.groups[entry.groupID] == nil {
+		c.groups[entry.groupID] = make(map[string]*list.Element)
+	}
+	c.groups[entry.groupID][entry.name] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.maxEntries {
+		c.remove(c.lru.Back())
+	}
+}
+
+// Removes an element. The cache must be locked
+func (c *quoteCache) remove(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*cacheEntry)
+	delete(c.groups[entry.groupID], entry.name)
+	if len(c.groups[entry.groupID]) == 0 {
+		delete(c.groups, entry.groupID)
+	}
+}
+
+// Drops everything cached for a group. Names are looked up with LIKE,
+// so any change could affect any cached name in the group
+func (c *quoteCache) invalidate(groupID string) {
+	c.Lock()
+	defer c.Unlock()
+	c.generations[groupID]++
+	for _, elem := range c.groups[groupID] {
+		c.remove(elem)
+	}
+}
+
+func (c *quoteCache) stats() CacheStats {
+	c.Lock()
+	defer c.Unlock()
+	return CacheStats{
+		Hits:    c.hits,
+		Misses:  c.misses,
+		Entries: c.lru.Len(),
+	}
+}
+
+// Gets a random quote from the given user through the cache
+func (d *MemeDB) cachedUserQuote(name string, callback srv.Callback) (Quote, error) {
+	entry, ok := d.cache.get(callback.GroupID, name)
+	if !ok {
+		generation := d.cache.generation(callback.GroupID)
+		quotes, err := d.GetQuotes(name, callback, cacheLoadLimit, QuoteIDSort)
+		if err != nil {
+			return Quote{}, err
+		}
+		entry = &cacheEntry{groupID: callback.GroupID, name: name, quotes: make(map[uint64]Quote, len(quotes))}
+		for _, quote := range quotes {
+			entry.ids = append(entry.ids, *quote.ID)
+			entry.quotes[*quote.ID] = quote
+		}
+		d.cache.put(entry, generation)
+	}
+	return entry.quotes[entry.ids[rand.Intn(len(entry.ids))]], nil
+}
+
+// Gets the hit and miss counts of the quote cache
+func (d *MemeDB) CacheStats() CacheStats {
+	if d.cache == nil {
+		return CacheStats{}
+	}
+	return d.cache.stats()
+}
@@ -0,0 +1,133 @@
+package adapter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuoteCacheLRU(t *testing.T) {
+	type op struct {
+		put bool
+		// group and name of the entry
+		group, name string
+		// for gets, whether it should be cached
+		hit bool
+	}
+	tests := []struct {
+		name       string
+		maxEntries int
+		ops        []op
+		entries    int
+	}{
+		{"miss when empty", 2, []op{
+			{false, "1", "a", false},
+		}, 0},
+		{"hit after put", 2, []op{
+			{true, "1", "a", false},
+			{false, "1", "a", true},
+			{false, "2", "a", false},
+		}, 1},
+		{"evicts least recently used", 2, []op{
+			{true, "1", "a", false},
+			{true, "1", "b", false},
+			{true, "1", "c", false},
+			{false, "1", "a", false},
+			{false, "1", "b", true},
+			{false, "1", "c", true},
+		}, 2},
+		{"get refreshes recency", 2, []op{
+			{true, "1", "a", false},
+			{true, "1", "b", false},
+			{false, "1", "a", true},
+			{true, "1", "c", false},
+			{false, "1", "a", true},
+			{false, "1", "b", false},
+		}, 2},
+		{"put replaces", 2, []op{
+			{true, "1", "a", false},
+			{true, "1", "a", false},
+			{true, "1", "b", false},
+			{false, "1", "a", true},
+			{false, "1", "b", true},
+		}, 2},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newQuoteCache(tc.maxEntries, time.Minute)
+			for n, o := range tc.ops {
+				if o.put {
+					c.put(&cacheEntry{groupID: o.group, name: o.name}, c.generation(o.group))
+					continue
+				}
+				if _, hit := c.get(o.group, o.name); hit != o.hit {
+					t.Errorf("op %d: get(%s, %s) hit is %v, want %v", n, o.group, o.name, hit, o.hit)
+				}
+			}
+			if entries := c.stats().Entries; entries != tc.entries {
+				t.Errorf("%d entries, want %d", entries, tc.entries)
+			}
+		})
+	}
+}
+
+func TestQuoteCacheTTL(t *testing.T) {
+	c := newQuoteCache(10, 20*time.Millisecond)
+	c.put(&cacheEntry{groupID: "1", name: "a"}, 0)
+	if _, hit := c.get("1", "a"); !hit {
+		t.Fatal("fresh entry missed")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if _, hit := c.get("1", "a"); hit {
+		t.Error("expired entry hit")
+	}
+	stats := c.stats()
+	if stats.Entries != 0 {
+		t.Errorf("expired entry is still kept")
+	}
+	if stats.Hits != 1 || stats.Misses != 1 {
+		t.Errorf("stats are %+v, want 1 hit and 1 miss", stats)
+	}
+}
+
+func TestQuoteCacheInvalidate(t *testing.T) {
+	c := newQuoteCache(10, time.Minute)
+	c.put(&cacheEntry{groupID: "1", name: "a"}, 0)
+	c.put(&cacheEntry{groupID: "1", name: "b"}, 0)
+	c.put(&cacheEntry{groupID: "2", name: "a"}, 0)
+	c.invalidate("1")
+	for _, tc := range []struct {
+		group, name string
+		hit         bool
+	}{{"1", "a", false}, {"1", "b", false}, {"2", "a", true}} {
+		if _, hit := c.get(tc.group, tc.name); hit != tc.hit {
+			t.Errorf("get(%s, %s) hit is %v, want %v", tc.group, tc.name, hit, tc.hit)
+		}
+	}
+}
+
+func TestQuoteCacheStalePut(t *testing.T) {
+	tests := []struct {
+		name string
+		// group invalidated while the quotes were loading, if any
+		invalidated string
+		hit         bool
+	}{
+		{"nothing changed", "", true},
+		{"group changed", "1", false},
+		{"other group changed", "2", true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newQuoteCache(10, time.Minute)
+			// a load starts, a quote is removed, then the load finishes
+			generation := c.generation("1")
+			if tc.invalidated != "" {
+				c.invalidate(tc.invalidated)
+			}
+			c.put(&cacheEntry{groupID: "1", name: "a"}, generation)
+			if _, hit := c.get("1", "a"); hit != tc.hit {
+				t.Errorf("hit is %v, want %v", hit, tc.hit)
+			}
+		})
+	}
+}
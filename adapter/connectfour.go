@@ -0,0 +1,129 @@
+package adapter
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// A Connect Four match in progress in a group
+type C4Game struct {
+	GroupID string
+	// Row-major cells from the top left. '.' is empty, 'R' and 'Y' are pieces
+	Board   string
+	RedID   string
+	RedName string
+	// the challenged player, from the mention in the challenge
+	YellowID   string
+	YellowName string
+	RedTurn    bool
+	LastMove   time.Time
+}
+
+// A player's record on the Connect Four leaderboard
+type C4Stats struct {
+	UserID string
+	Name   string
+	Wins   int
+	Losses int
+	Draws  int
+}
+
+// Gets the game being played in the group. Returns a nil game if there isn't one
+func (d *MemeDB) GetC4Game(groupID string) (*C4Game, error) {
+	id, err := parseGroupID(groupID)
+	if err != nil {
+		return nil, err
+	}
+	game := C4Game{GroupID: groupID}
+	err = d.queryRow("GetC4Game", "SELECT board, red_id, red_name, yellow_id, yellow_name, red_turn, last_move "+
+		"FROM c4_games WHERE group_id=$1", []interface{}{id}, &game.Board, &game.RedID, &game.RedName,
+		&game.YellowID, &game.YellowName, &game.RedTurn, &game.LastMove)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &game, nil
+}
+
+// Creates or updates the group's game
+func (d *MemeDB) SaveC4Game(game C4Game) error {
+	id, err := parseGroupID(game.GroupID)
+	if err != nil {
+		return err
+	}
+	_, err = d.execIdempotent("SaveC4Game", "INSERT INTO c4_games (group_id, board, red_id, red_name, yellow_id, yellow_name, red_turn, last_move) "+
+		"VALUES ($1, $2, $3, $4, $5, $6, $7, $8) ON CONFLICT (group_id) DO UPDATE SET "+
+		"board=EXCLUDED.board, red_id=EXCLUDED.red_id, red_name=EXCLUDED.red_name, yellow_id=EXCLUDED.yellow_id, "+
+		"yellow_name=EXCLUDED.yellow_name, red_turn=EXCLUDED.red_turn, last_move=EXCLUDED.last_move",
+		id, game.Board, game.RedID, game.RedName, game.YellowID, game.YellowName, game.RedTurn, game.LastMove)
+	return err
+}
+
+// Ends the group's game without recording a result
+func (d *MemeDB) DeleteC4Game(groupID string) error {
+	id, err := parseGroupID(groupID)
+	if err != nil {
+		return err
+	}
+	_, err = d.execIdempotent("DeleteC4Game", "DELETE FROM c4_games WHERE group_id=$1", id)
+	return err
+}
+
+// Ends the group's game and updates both players' records. If draw is
+// true neither player is the winner and both get a draw.
+func (d *MemeDB) FinishC4Game(game C4Game, winnerID string, draw bool) error {
+	id, err := parseGroupID(game.GroupID)
+	if err != nil {
+		return err
+	}
+	players := []struct{ id, name string }{{game.RedID, game.RedName}, {game.YellowID, game.YellowName}}
+	return d.transaction("FinishC4Game", func(ctx context.Context, tx *sql.Tx) error {
+		for _, player := range players {
+			var wins, losses, draws int
+			switch {
+			case draw:
+				draws = 1
+			case player.id == winnerID:
+				wins = 1
+			default:
+				losses = 1
+			}
+			_, err := tx.ExecContext(ctx, "INSERT INTO c4_stats (group_id, user_id, name, wins, losses, draws) "+
+				"VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (group_id, user_id) DO UPDATE SET name=EXCLUDED.name, "+
+				"wins=c4_stats.wins+EXCLUDED.wins, losses=c4_stats.losses+EXCLUDED.losses, draws=c4_stats.draws+EXCLUDED.draws",
+				id, player.id, player.name, wins, losses, draws)
+			if err != nil {
+				return err
+			}
+		}
+		_, err := tx.ExecContext(ctx, "DELETE FROM c4_games WHERE group_id=$1", id)
+		return err
+	})
+}
+
+// Gets the group's best Connect Four players, most wins first
+func (d *MemeDB) GetC4Leaderboard(groupID string, limit int) (stats []C4Stats, err error) {
+	id, err := parseGroupID(groupID)
+	if err != nil {
+		return nil, err
+	}
+	err = d.query("GetC4Leaderboard", func(rows *sql.Rows) error {
+		stats = nil
+		for rows.Next() {
+			var s C4Stats
+			if err := rows.Scan(&s.UserID, &s.Name, &s.Wins, &s.Losses, &s.Draws); err != nil {
+				return err
+			}
+			stats = append(stats, s)
+		}
+		return nil
+	}, "SELECT user_id, name, wins, losses, draws FROM c4_stats "+
+		"WHERE group_id=$1 ORDER BY wins DESC, losses ASC LIMIT $2", id, limit)
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
@@ -0,0 +1,40 @@
+package adapter
+
+import (
+	"strconv"
+	"time"
+)
+
+// Creates a token that opens the group's dashboard until it expires
+func (d *MemeDB) CreateDashboardToken(groupID string, userID string, ttl time.Duration) (string, error) {
+	id, err := parseGroupID(groupID)
+	if err != nil {
+		return "", err
+	}
+	token, err := newQuoteKey()
+	if err != nil {
+		return "", err
+	}
+	// clear out old tokens while we're here
+	if _, err := d.execIdempotent("CreateDashboardToken", "DELETE FROM dashboard_tokens WHERE expires < now()"); err != nil {
+		return "", err
+	}
+	_, err = d.exec("CreateDashboardToken", "INSERT INTO dashboard_tokens (token, group_id, user_id, expires) "+
+		"VALUES ($1, $2, $3, $4)", token, id, userID, time.Now().Add(ttl))
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Gets the group and user a dashboard token was made for. Returns
+// ErrNotFound if it doesn't exist or has expired
+func (d *MemeDB) GetDashboardToken(token string) (groupID string, userID string, err error) {
+	var id uint64
+	err = d.queryRow("GetDashboardToken", "SELECT group_id, user_id FROM dashboard_tokens "+
+		"WHERE token=$1 AND expires > now()", []interface{}{token}, &id, &userID)
+	if err != nil {
+		return "", "", err
+	}
+	return strconv.FormatUint(id, 10), userID, nil
+}
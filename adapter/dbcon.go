@@ -2,11 +2,12 @@ package adapter
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"errors"
-	"fmt"
 	srv "github.com/ethanzeigler/groupme/botserver"
-	_ "github.com/lib/pq"
+	"github.com/ethanzeigler/groupme/gmbots/filter"
+	"github.com/lib/pq"
 	"strconv"
 	"time"
 )
@@ -17,6 +18,8 @@ const (
 	QuoteIDSort SortType = 0
 	DateSort SortType = 1
 	RandomSort SortType = 2
+	// random, but higher scoring quotes come up more often
+	WeightedRandomSort SortType = 3
 )
 
 // Represents a Row inside of the quote db table
@@ -27,15 +30,32 @@ type Quote struct {
 	Date *time.Time
 	GroupID *uint64
 	SubmitterID *string
+	// name the submitter went by when recording the quote. Nil for
+	// quotes recorded before names were kept
+	SubmitterName *string
+	// labels given to the quote on the dashboard
+	Tags []string
 }
 
 
 type MemeDB struct {
 	// the database connection
 	db *sql.DB
+	// called whenever quotes are added or removed
+	listeners []func(groupID string, name string)
+	options   DBOptions
+	// quote writes waiting for the database. Nil if not enabled
+	queue *writeQueue
+	// quotes by group and person. Nil if not enabled
+	cache *quoteCache
+	// checks quotes before they're saved. Nil if not enabled
+	filter filter.Filter
+	// how each group handles filtered quotes, by group ID
+	policies map[string]filter.Policy
 }
 
-func NewMemeDB(conStr string) (*MemeDB, error) {
+// Opens the database and checks that it can be reached
+func NewMemeDB(conStr string, options DBOptions) (*MemeDB, error) {
 	var memeDB MemeDB
 	var err error
 	// open heroku db connection
@@ -47,14 +67,29 @@ func NewMemeDB(conStr string) (*MemeDB, error) {
 	memeDB.db, err = sql.Open("postgres", conStr)
 	if err != nil {
 		return nil, err
-	} else {
-		return &memeDB, nil
 	}
+	memeDB.options = options.withDefaults()
+	memeDB.db.SetMaxOpenConns(memeDB.options.MaxOpenConns)
+	memeDB.db.SetMaxIdleConns(memeDB.options.MaxIdleConns)
+	memeDB.db.SetConnMaxLifetime(memeDB.options.ConnMaxLifetime)
+	if memeDB.options.CacheSize > 0 {
+		memeDB.cache = newQuoteCache(memeDB.options.CacheSize, memeDB.options.CacheTTL)
+	}
+
+	// sql.Open doesn't connect, so make sure the database is really there
+	if err = memeDB.Ping(); err != nil {
+		_ = memeDB.db.Close()
+		return nil, err
+	}
+	return &memeDB, nil
 }
 
 
 // gets a random quote from the given user
 func (d *MemeDB) GetUserQuote(name string, callback srv.Callback) (quoteRow Quote, err error) {
+	if d.cache != nil {
+		return d.cachedUserQuote(name, callback)
+	}
 	quotes, err := d.GetQuotes(name, callback, 1, RandomSort)
 
 	if err != nil {
@@ -63,79 +98,263 @@ func (d *MemeDB) GetUserQuote(name string, callback srv.Callback) (quoteRow Quot
 	return quotes[0], err
 }
 
+// Registers a function to call whenever a quote is added to or removed
+// from a group, with the name the quote is filed under
+func (d *MemeDB) AddChangeListener(listener func(groupID string, name string)) {
+	d.listeners = append(d.listeners, listener)
+}
+
+func (d *MemeDB) notifyChange(groupID string, name string) {
+	if d.cache != nil {
+		d.cache.invalidate(groupID)
+	}
+	for _, listener := range d.listeners {
+		listener(groupID, name)
+	}
+}
+
+// Runs quotes through a content filter before they're saved. Each group's
+// policy decides whether matches are blocked, masked or let through
+func (d *MemeDB) UseContentFilter(f filter.Filter, policies map[string]filter.Policy) {
+	d.filter = f
+	d.policies = policies
+}
+
+// Records a quote said just now. If the database can't be reached and
+// the write queue is enabled, the quote is queued and ErrQueued is returned.
 func (d *MemeDB) WriteUserQuote(name string, quote string, callback srv.Callback) error {
-	curr := time.Now()
-	groupID, err := strconv.Atoi(callback.GroupID)
+	return d.WriteUserQuoteOn(name, quote, time.Now(), callback)
+}
+
+// Records a quote said at the given time, which is stored in UTC
+func (d *MemeDB) WriteUserQuoteOn(name string, quote string, date time.Time, callback srv.Callback) error {
+	_, err := d.writeQuote(name, quote, date, statusApproved, callback)
+	return err
+}
+
+// Records a quote like WriteUserQuoteOn and returns the new quote's ID.
+// The ID is 0 when ErrQueued is returned, since queued quotes don't have one yet
+func (d *MemeDB) CreateQuote(name string, quote string, date time.Time, callback srv.Callback) (uint64, error) {
+	return d.writeQuote(name, quote, date, statusApproved, callback)
+}
+
+func (d *MemeDB) writeQuote(name string, quote string, date time.Time, status string, callback srv.Callback) (uint64, error) {
+	if _, err := parseGroupID(callback.GroupID); err != nil {
+		return 0, err
+	}
+	if d.filter != nil {
+		result := filter.Apply(d.filter, d.policies[callback.GroupID], quote)
+		if result.Blocked {
+			return 0, ErrFiltered
+		}
+		quote = result.Text
+	}
+	optedOut, err := d.isOptedOut(callback.GroupID, name)
+	if err != nil && !errors.Is(err, ErrUnavailable) {
+		return 0, err
+	} else if optedOut {
+		return 0, ErrOptedOut
+	}
+	key, err := newQuoteKey()
 	if err != nil {
+		return 0, err
+	}
+	entry := queuedQuote{
+		Key: key, Name: name, Quote: quote, GroupID: callback.GroupID,
+		Date: date.UTC().Format(time.RFC3339), Submitter: callback.SenderID, SubmitterName: callback.Name,
+		Status: status}
+
+	// keep quotes in order behind anything still waiting to sync
+	if d.QueueLength() > 0 {
+		return 0, d.enqueue(entry)
+	}
+	id, err := d.insertQuote(entry)
+	if errors.Is(err, ErrUnavailable) && d.queue != nil {
+		return 0, d.enqueue(entry)
+	} else if err != nil {
+		return 0, err
+	} else if id == 0 {
+		// they opted out since it was checked
+		return 0, ErrOptedOut
+	}
+	d.notifyChange(callback.GroupID, name)
+	return id, nil
+}
+
+func (d *MemeDB) enqueue(entry queuedQuote) error {
+	if err := d.queue.push(entry); err != nil {
 		return err
 	}
-	_, err = d.db.Exec("INSERT INTO quotes (name, quote, group_id, date, submit_by) VALUES ($1, $2, $3, to_date($4,'YYYY-MM-DD'), $5)",
-		name, quote, groupID, fmt.Sprintf("%d-%02d-%02d\n",
-			curr.Year(), curr.Month(), curr.Day()), callback.SenderID)
+	return ErrQueued
+}
+
+// Inserts a quote, doing nothing if one with the same key was already
+// inserted or the person it names opted out while it was queued. Returns
+// the quote's ID, or 0 if they opted out
+func (d *MemeDB) insertQuote(entry queuedQuote) (uint64, error) {
+	groupID, err := parseGroupID(entry.GroupID)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	return nil
+	date, err := entry.date()
+	if err != nil {
+		return 0, err
+	}
+	var submitName sql.NullString
+	if entry.SubmitterName != "" {
+		submitName = sql.NullString{String: entry.SubmitterName, Valid: true}
+	}
+	status := entry.Status
+	if status == "" {
+		status = statusApproved
+	}
+	// the key makes this safe to retry. A retry that finds the quote
+	// already inserted gets the ID from the existing row
+	var id uint64
+	err = d.queryRow("WriteUserQuote", "WITH inserted AS (INSERT INTO quotes "+
+		"(name, quote, group_id, date, submit_by, submit_name, idempotency_key, status) "+
+		"SELECT $1, $2, $3, $4, $5, $6, $7, $8 WHERE NOT EXISTS (SELECT 1 FROM quote_identities "+
+		"WHERE group_id=$3 AND name=lower($1) AND opted_out AND verified) ON CONFLICT (idempotency_key) DO NOTHING RETURNING id) "+
+		"SELECT id FROM inserted UNION ALL SELECT id FROM quotes WHERE idempotency_key=$7 LIMIT 1",
+		[]interface{}{entry.Name, entry.Quote, groupID, date, entry.Submitter, submitName, entry.Key, status}, &id)
+	if errors.Is(err, ErrNotFound) {
+		return 0, nil
+	}
+	return id, err
 }
 
 func (d *MemeDB) GetQuotes(name string, callback srv.Callback, limit int, sortType SortType) (quotes []Quote, err error) {
-	groupID, err := strconv.Atoi(callback.GroupID)
+	groupID, err := parseGroupID(callback.GroupID)
 	if err != nil {
 		return make([]Quote, 0, 1), err
 	}
-	var rows *sql.Rows
+	var order string
 	switch sortType {
 	case DateSort:
-		rows, err = d.db.Query("SELECT id, name, quote, group_id, date, submit_by FROM quotes " +
-			"WHERE name LIKE $1 AND group_id=$2 ORDER BY date DESC LIMIT $3", name, groupID, limit)
-		break
+		order = "date DESC"
 	case QuoteIDSort:
-		rows, err = d.db.Query("SELECT id, name, quote, group_id, date, submit_by FROM quotes " +
-			"WHERE name LIKE $1 AND group_id=$2 ORDER BY id DESC LIMIT $3", name, groupID, limit)
-		break
+		order = "id DESC"
 	case RandomSort:
-		rows, err = d.db.Query("SELECT id, name, quote, group_id, date, submit_by FROM quotes " +
-			"WHERE name LIKE $1 AND group_id=$2 ORDER BY random() LIMIT $3", name, groupID, limit)
-		break
+		order = "random()"
+	case WeightedRandomSort:
+		// exponential races: each quote draws a time at a rate of its
+		// weight and the earliest wins, so picks are proportional to weight
+		order = "-ln(1 - random()) / " + quoteWeight
 	default:
 		return make([]Quote, 0, 1), errors.New("illegal SortType")
 	}
 
+	err = d.query("GetQuotes", func(rows *sql.Rows) error {
+		quotes = nil
+		for rows.Next() {
+			quote, err := scanQuote(rows)
+			if err != nil {
+				return err
+			}
+			quotes = append(quotes, quote)
+		}
+		return nil
+	}, "SELECT "+quoteColumns+" FROM quotes "+
+		"WHERE name LIKE $1 AND group_id=$2 AND status='approved' ORDER BY "+order+" LIMIT $3", name, groupID, limit)
 	if err != nil {
 		return make([]Quote, 0, 1), err
 	}
-	var i = 0
-	for ; rows.Next(); i++ {
-		var name, quote, submitterID string
-		var date time.Time
-		var quoteID, groupID uint64
-
-		err := rows.Scan(&quoteID, &name, &quote, &groupID, &date, &submitterID)
-		if err != nil {
-			return make([]Quote, 0, 1), err
-		}
-		quotes = append(quotes, Quote{
-			Name: &name, Quote: &quote,
-			Date: &date, GroupID: &groupID,
-			ID:&quoteID, SubmitterID:&submitterID})
-	}
 
 	if len(quotes) == 0 {
-		return make([]Quote, 0, 1), errors.New("no quotes found")
+		return make([]Quote, 0, 1), ErrNotFound
 	}
 	err = nil
 	return
 }
 
 func (d *MemeDB) DeleteQuote(quote Quote) (sql.Result, error){
-	return d.db.Exec("DELETE FROM quotes WHERE id=$1", quote.ID)
+	result, err := d.execIdempotent("DeleteQuote", "DELETE FROM quotes WHERE id=$1", quote.ID)
+	if err == nil && quote.GroupID != nil && quote.Name != nil {
+		d.notifyChange(strconv.FormatUint(*quote.GroupID, 10), *quote.Name)
+	}
+	return result, err
+}
+
+// Columns scanQuote expects, in order
+const quoteColumns = "id, name, quote, group_id, date, submit_by, submit_name, tags"
+
+// Scans a row selected with quoteColumns
+func scanQuote(row interface{ Scan(dest ...interface{}) error }) (Quote, error) {
+	var name, quote, submitterID string
+	var submitterName sql.NullString
+	var date time.Time
+	var quoteID, groupID uint64
+	var tags []string
+
+	err := row.Scan(&quoteID, &name, &quote, &groupID, &date, &submitterID, &submitterName, pq.Array(&tags))
+	if err != nil {
+		return Quote{}, err
+	}
+	result := Quote{
+		Name: &name, Quote: &quote,
+		Date: &date, GroupID: &groupID,
+		ID: &quoteID, SubmitterID: &submitterID, Tags: tags}
+	if submitterName.Valid {
+		result.SubmitterName = &submitterName.String
+	}
+	return result, nil
+}
+
+// Gets a quote by its ID. Quotes from other groups aren't found
+func (d *MemeDB) GetQuoteByID(groupID string, id uint64) (quote Quote, err error) {
+	group, err := parseGroupID(groupID)
+	if err != nil {
+		return Quote{}, err
+	}
+	err = d.withRetry("GetQuoteByID", func(ctx context.Context) error {
+		var scanErr error
+		quote, scanErr = scanQuote(d.db.QueryRowContext(ctx,
+			"SELECT "+quoteColumns+" FROM quotes WHERE id=$1 AND group_id=$2 AND status='approved'", id, group))
+		return scanErr
+	})
+	return quote, err
+}
+
+// Gets a random quote from anyone in the group
+func (d *MemeDB) GetRandomQuote(callback srv.Callback) (Quote, error) {
+	quotes, err := d.GetQuotes("%", callback, 1, RandomSort)
+	if err != nil {
+		return Quote{}, err
+	}
+	return quotes[0], nil
+}
+
+// Gets the names quotes in the group are filed under
+func (d *MemeDB) GetQuoteeNames(groupID string) (names []string, err error) {
+	id, err := parseGroupID(groupID)
+	if err != nil {
+		return nil, err
+	}
+	err = d.query("GetQuoteeNames", func(rows *sql.Rows) error {
+		names = nil
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				return err
+			}
+			names = append(names, name)
+		}
+		return nil
+	}, "SELECT DISTINCT name FROM quotes WHERE group_id=$1 AND status='approved'", id)
+	if err != nil {
+		return nil, err
+	}
+	return names, nil
 }
 
 func (d *MemeDB) TestQuery(buffer *bytes.Buffer) error {
-	rows, err := d.db.Query("SELECT * FROM quotes")
+	ctx, cancel := context.WithTimeout(context.Background(), d.options.QueryTimeout)
+	defer cancel()
+	rows, err := d.db.QueryContext(ctx, "SELECT * FROM quotes")
 	if err != nil {
 		return err
 	}
+	defer rows.Close()
 
 	for i:= 0; rows.Next(); i++ {
 		row, err := rows.Columns()
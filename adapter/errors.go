@@ -0,0 +1,92 @@
+package adapter
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/lib/pq"
+)
+
+// Kinds of errors returned by MemeDB. Check for them with errors.Is
+var (
+	ErrNotFound       = errors.New("no quotes found")
+	ErrInvalidGroupID = errors.New("invalid group ID")
+	ErrConstraint     = errors.New("constraint violation")
+	ErrUnavailable    = errors.New("database unavailable")
+	// the person a quote names has opted out of being quoted
+	ErrOptedOut = errors.New("person opted out of quotes")
+	// the name is linked to someone else
+	ErrNameTaken = errors.New("name linked to another user")
+	// the content filter blocked the quote
+	ErrFiltered = errors.New("quote blocked by content filter")
+)
+
+// An error from a database operation. Kind is one of the errors above if
+// the cause is known, and Err is the error from the driver.
+type DBError struct {
+	Op   string
+	Kind error
+	Err  error
+}
+
+func (e *DBError) Error() string {
+	if e.Kind != nil {
+		return fmt.Sprintf("%s: %s: %s", e.Op, e.Kind.Error(), e.Err.Error())
+	}
+	return fmt.Sprintf("%s: %s", e.Op, e.Err.Error())
+}
+
+func (e *DBError) Unwrap() error {
+	return e.Err
+}
+
+func (e *DBError) Is(target error) bool {
+	return e.Kind != nil && target == e.Kind
+}
+
+// Returned when a group ID isn't the number the database expects
+type InvalidGroupIDError struct {
+	GroupID string
+}
+
+func (e *InvalidGroupIDError) Error() string {
+	return fmt.Sprintf("invalid group ID %q", e.GroupID)
+}
+
+func (e *InvalidGroupIDError) Is(target error) bool {
+	return target == ErrInvalidGroupID
+}
+
+// Converts a GroupMe group ID to the number it's stored as
+func parseGroupID(groupID string) (uint64, error) {
+	id, err := strconv.ParseUint(groupID, 10, 64)
+	if err != nil {
+		return 0, &InvalidGroupIDError{GroupID: groupID}
+	}
+	return id, nil
+}
+
+// Wraps an error from the driver in a DBError with its kind worked out
+func wrapErr(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var dbErr *DBError
+	if errors.As(err, &dbErr) {
+		return err
+	}
+	wrapped := &DBError{Op: op, Err: err}
+	var pqErr *pq.Error
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		wrapped.Kind = ErrNotFound
+	case errors.As(err, &pqErr) && pqErr.Code.Class() == "23":
+		// integrity constraint violation
+		wrapped.Kind = ErrConstraint
+	case isTransient(err):
+		wrapped.Kind = ErrUnavailable
+	}
+	return wrapped
+}
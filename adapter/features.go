@@ -0,0 +1,68 @@
+package adapter
+
+import (
+	"database/sql"
+)
+
+// Gets the per-group feature overrides. Features without a row are
+// left out of the map and should be treated as enabled.
+func (d *MemeDB) GetFeatureFlags(groupID string) (flags map[string]bool, err error) {
+	id, err := parseGroupID(groupID)
+	if err != nil {
+		return nil, err
+	}
+	err = d.query("GetFeatureFlags", func(rows *sql.Rows) error {
+		flags = make(map[string]bool)
+		for rows.Next() {
+			var feature string
+			var enabled bool
+			if err := rows.Scan(&feature, &enabled); err != nil {
+				return err
+			}
+			flags[feature] = enabled
+		}
+		return nil
+	}, "SELECT feature, enabled FROM group_features WHERE group_id=$1", id)
+	if err != nil {
+		return nil, err
+	}
+	return flags, nil
+}
+
+// Enables or disables a feature for the given group
+func (d *MemeDB) SetFeatureFlag(groupID string, feature string, enabled bool) error {
+	id, err := parseGroupID(groupID)
+	if err != nil {
+		return err
+	}
+	_, err = d.execIdempotent("SetFeatureFlag", "INSERT INTO group_features (group_id, feature, enabled) VALUES ($1, $2, $3) "+
+		"ON CONFLICT (group_id, feature) DO UPDATE SET enabled=EXCLUDED.enabled", id, feature, enabled)
+	return err
+}
+
+// Gets the rollout stages features have been promoted or demoted to.
+// Features without a row keep the stage they were registered with.
+func (d *MemeDB) GetFeatureStages() (stages map[string]string, err error) {
+	err = d.query("GetFeatureStages", func(rows *sql.Rows) error {
+		stages = make(map[string]string)
+		for rows.Next() {
+			var feature, stage string
+			if err := rows.Scan(&feature, &stage); err != nil {
+				return err
+			}
+			stages[feature] = stage
+		}
+		return nil
+	}, "SELECT feature, stage FROM feature_stages")
+	if err != nil {
+		return nil, err
+	}
+	return stages, nil
+}
+
+// Moves a feature to a different rollout stage in every group
+func (d *MemeDB) SetFeatureStage(feature string, stage string) error {
+	_, err := d.execIdempotent("SetFeatureStage", "INSERT INTO feature_stages (feature, stage) VALUES ($1, $2) "+
+		"ON CONFLICT (feature) DO UPDATE SET stage=EXCLUDED.stage", feature, stage)
+	return err
+}
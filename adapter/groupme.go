@@ -0,0 +1,124 @@
+package adapter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Base URL of the GroupMe API
+const DefaultGroupMeAPIURL = "https://api.groupme.com/v3"
+
+// Reads from the GroupMe API with a user's access token. Bots can post
+// but can't see likes, so anything past posting goes through here
+type GroupMeAPI struct {
+	Endpoint string
+	Token    string
+	client   *http.Client
+}
+
+// Creates a GroupMe API client. An empty endpoint uses DefaultGroupMeAPIURL
+func NewGroupMeAPI(endpoint string, token string) *GroupMeAPI {
+	if endpoint == "" {
+		endpoint = DefaultGroupMeAPIURL
+	}
+	return &GroupMeAPI{
+		Endpoint: endpoint,
+		Token:    token,
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Sends a request with the token in a header. In the URL it would end up
+// in logs and in the errors returned by the client
+func (g *GroupMeAPI) do(req *http.Request) (*http.Response, error) {
+	req.Header.Set("X-Access-Token", g.Token)
+	return g.client.Do(req)
+}
+
+// Sends direct messages. Bots can't, so this is a user's API client like
+// GroupMeAPI
+type DirectMessenger interface {
+	SendDirectMessage(userID string, text string) error
+}
+
+// A message and how many people liked it
+type LikedMessage struct {
+	ID    string
+	Likes int
+}
+
+// Gets the most liked messages of a group over a period: "day", "week" or "month"
+func (g *GroupMeAPI) GetLikedMessages(groupID string, period string) ([]LikedMessage, error) {
+	endpoint := fmt.Sprintf("%s/groups/%s/likes?period=%s", g.Endpoint,
+		url.PathEscape(groupID), url.QueryEscape(period))
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := g.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("GroupMe API responded %s", resp.Status)
+	}
+
+	var body struct {
+		Response struct {
+			Messages []struct {
+				ID          string   `json:"id"`
+				FavoritedBy []string `json:"favorited_by"`
+			} `json:"messages"`
+		} `json:"response"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	messages := make([]LikedMessage, 0, len(body.Response.Messages))
+	for _, message := range body.Response.Messages {
+		messages = append(messages, LikedMessage{ID: message.ID, Likes: len(message.FavoritedBy)})
+	}
+	return messages, nil
+}
+
+// Sends a direct message from the token's user to another user
+func (g *GroupMeAPI) SendDirectMessage(userID string, text string) error {
+	guid, err := newQuoteKey()
+	if err != nil {
+		return err
+	}
+	var body struct {
+		DirectMessage struct {
+			SourceGUID  string `json:"source_guid"`
+			RecipientID string `json:"recipient_id"`
+			Text        string `json:"text"`
+		} `json:"direct_message"`
+	}
+	body.DirectMessage.SourceGUID = guid
+	body.DirectMessage.RecipientID = userID
+	body.DirectMessage.Text = text
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, g.Endpoint+"/direct_messages", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := g.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("GroupMe API responded %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package adapter
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// GroupMe's image service. Pictures have to be hosted there before
+// they can be posted
+const DefaultImageServiceURL = "https://image.groupme.com/pictures"
+
+// Uploads images so they can be posted with srv.Message.Picture
+type ImageService struct {
+	// URL images are POSTed to
+	Endpoint string
+	// GroupMe access token sent as X-Access-Token
+	Token  string
+	client *http.Client
+}
+
+// Creates an image service client. An empty endpoint uses GroupMe's
+func NewImageService(endpoint string, token string) *ImageService {
+	if endpoint == "" {
+		endpoint = DefaultImageServiceURL
+	}
+	return &ImageService{
+		Endpoint: endpoint,
+		Token:    token,
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Body the image service responds with
+type imageResponse struct {
+	Payload struct {
+		URL        string `json:"url"`
+		PictureURL string `json:"picture_url"`
+	} `json:"payload"`
+}
+
+// Uploads an image with the given content type, like "image/png",
+// and returns the URL it can be posted with
+func (s *ImageService) Upload(image []byte, contentType string) (string, error) {
+	req, err := http.NewRequest(http.MethodPost, s.Endpoint, bytes.NewReader(image))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if s.Token != "" {
+		req.Header.Set("X-Access-Token", s.Token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("image service responded %s", resp.Status)
+	}
+
+	var body imageResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	if body.Payload.PictureURL != "" {
+		return body.Payload.PictureURL, nil
+	}
+	if body.Payload.URL != "" {
+		return body.Payload.URL, nil
+	}
+	return "", errors.New("image service didn't return a URL")
+}
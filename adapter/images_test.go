@@ -0,0 +1,55 @@
+package adapter
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestImageServiceUpload(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		want    string
+		wantErr bool
+	}{
+		{"picture url", http.StatusOK, `{"payload":{"url":"https://i.groupme.com/a","picture_url":"https://i.groupme.com/b"}}`, "https://i.groupme.com/b", false},
+		{"url only", http.StatusOK, `{"payload":{"url":"https://i.groupme.com/a"}}`, "https://i.groupme.com/a", false},
+		{"no url", http.StatusOK, `{"payload":{}}`, "", true},
+		{"bad json", http.StatusOK, `not json`, "", true},
+		{"server error", http.StatusServiceUnavailable, ``, "", true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var gotToken, gotType string
+			var gotBody []byte
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotToken = r.Header.Get("X-Access-Token")
+				gotType = r.Header.Get("Content-Type")
+				gotBody, _ = io.ReadAll(r.Body)
+				w.WriteHeader(tc.status)
+				_, _ = io.WriteString(w, tc.body)
+			}))
+			defer server.Close()
+
+			url, err := NewImageService(server.URL, "secret").Upload([]byte("png bytes"), "image/png")
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("err is %v, want error %v", err, tc.wantErr)
+			}
+			if url != tc.want {
+				t.Errorf("url is %q, want %q", url, tc.want)
+			}
+			if gotToken != "secret" {
+				t.Errorf("token header is %q, want secret", gotToken)
+			}
+			if gotType != "image/png" {
+				t.Errorf("content type is %q, want image/png", gotType)
+			}
+			if string(gotBody) != "png bytes" {
+				t.Errorf("body is %q, want the image", gotBody)
+			}
+		})
+	}
+}
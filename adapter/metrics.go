@@ -0,0 +1,32 @@
+package adapter
+
+import (
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	queryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "gmbots",
+		Subsystem: "db",
+		Name:      "query_duration_seconds",
+		Help:      "Time MemeDB operations take, retries included, by operation.",
+	}, []string{"op"})
+	queryErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gmbots",
+		Subsystem: "db",
+		Name:      "query_errors_total",
+		Help:      "MemeDB operations that failed after any retries, by operation. Missing rows don't count.",
+	}, []string{"op"})
+)
+
+// Records how long an operation took and whether it failed
+func observeQuery(op string, start time.Time, err error) {
+	queryDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		queryErrors.WithLabelValues(op).Inc()
+	}
+}
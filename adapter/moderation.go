@@ -0,0 +1,78 @@
+package adapter
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"time"
+
+	srv "github.com/ethanzeigler/groupme/botserver"
+)
+
+// Review states of a quote
+const (
+	statusApproved = "approved"
+	statusPending  = "pending"
+	statusRejected = "rejected"
+)
+
+// Records a quote that won't be shown until a moderator approves it
+func (d *MemeDB) WritePendingQuoteOn(name string, quote string, date time.Time, callback srv.Callback) error {
+	_, err := d.writeQuote(name, quote, date, statusPending, callback)
+	return err
+}
+
+// Gets the oldest quotes in the group waiting for a moderator
+func (d *MemeDB) GetPendingQuotes(groupID string, limit int) (quotes []Quote, err error) {
+	id, err := parseGroupID(groupID)
+	if err != nil {
+		return nil, err
+	}
+	err = d.query("GetPendingQuotes", func(rows *sql.Rows) error {
+		quotes = nil
+		for rows.Next() {
+			quote, err := scanQuote(rows)
+			if err != nil {
+				return err
+			}
+			quotes = append(quotes, quote)
+		}
+		return nil
+	}, "SELECT "+quoteColumns+" FROM quotes WHERE group_id=$1 AND status='pending' ORDER BY id LIMIT $2", id, limit)
+	if err != nil {
+		return nil, err
+	}
+	return quotes, nil
+}
+
+// Moves a pending quote to the given status. Returns ErrNotFound if the
+// group has no pending quote with that ID
+func (d *MemeDB) reviewQuote(op string, groupID string, id uint64, status string, reason sql.NullString) (Quote, error) {
+	group, err := parseGroupID(groupID)
+	if err != nil {
+		return Quote{}, err
+	}
+	var quote Quote
+	// a repeat after the first attempt committed wouldn't find it pending
+	err = d.withWriteRetry(op, func(ctx context.Context) error {
+		var scanErr error
+		quote, scanErr = scanQuote(d.db.QueryRowContext(ctx, "UPDATE quotes SET status=$3, reject_reason=$4 "+
+			"WHERE id=$1 AND group_id=$2 AND status='pending' RETURNING "+quoteColumns, id, group, status, reason))
+		return scanErr
+	})
+	return quote, err
+}
+
+// Makes a pending quote visible
+func (d *MemeDB) ApproveQuote(groupID string, id uint64) (Quote, error) {
+	quote, err := d.reviewQuote("ApproveQuote", groupID, id, statusApproved, sql.NullString{})
+	if err == nil {
+		d.notifyChange(strconv.FormatUint(*quote.GroupID, 10), *quote.Name)
+	}
+	return quote, err
+}
+
+// Turns down a pending quote. It's kept, along with the reason, but never shown
+func (d *MemeDB) RejectQuote(groupID string, id uint64, reason string) (Quote, error) {
+	return d.reviewQuote("RejectQuote", groupID, id, statusRejected, sql.NullString{String: reason, Valid: reason != ""})
+}
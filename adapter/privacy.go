@@ -0,0 +1,202 @@
+package adapter
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+// Returns true if the person filed under name has opted out of being
+// quoted. Claims that aren't verified don't count
+func (d *MemeDB) isOptedOut(groupID string, name string) (optedOut bool, err error) {
+	id, err := parseGroupID(groupID)
+	if err != nil {
+		return false, err
+	}
+	err = d.queryRow("IsOptedOut", "SELECT opted_out AND verified FROM quote_identities WHERE group_id=$1 AND name=$2",
+		[]interface{}{id, strings.ToLower(name)}, &optedOut)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	return optedOut, err
+}
+
+// A name someone has claimed as their own
+type Claim struct {
+	Name     string
+	UserID   string
+	UserName string
+	OptedOut bool
+	// the name matched the claimant or an admin approved the claim
+	Verified bool
+}
+
+// Links a quote name to a user and sets whether they can be quoted under
+// it. Unverified claims wait for an admin and don't take effect until
+// then. A verified claim replaces someone else's unverified one. Returns
+// whether the claim is verified, or ErrNameTaken if someone else holds the name
+func (d *MemeDB) SetOptOut(groupID string, userID string, userName string, name string, optedOut bool,
+	verified bool) (bool, error) {
+	id, err := parseGroupID(groupID)
+	if err != nil {
+		return false, err
+	}
+	err = d.queryRow("SetOptOut", "INSERT INTO quote_identities (group_id, name, user_id, user_name, opted_out, verified) "+
+		"VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (group_id, name) DO UPDATE SET user_id=EXCLUDED.user_id, "+
+		"user_name=EXCLUDED.user_name, opted_out=EXCLUDED.opted_out, verified=quote_identities.verified OR EXCLUDED.verified "+
+		"WHERE quote_identities.user_id=EXCLUDED.user_id OR (EXCLUDED.verified AND NOT quote_identities.verified) "+
+		"RETURNING verified", []interface{}{id, strings.ToLower(name), userID, userName, optedOut, verified}, &verified)
+	if errors.Is(err, ErrNotFound) {
+		// the update was skipped, so the name is someone else's
+		return false, ErrNameTaken
+	}
+	return verified, err
+}
+
+// Gets the claims waiting for an admin
+func (d *MemeDB) GetPendingClaims(groupID string) (claims []Claim, err error) {
+	id, err := parseGroupID(groupID)
+	if err != nil {
+		return nil, err
+	}
+	err = d.query("GetPendingClaims", func(rows *sql.Rows) error {
+		claims = nil
+		for rows.Next() {
+			var claim Claim
+			if err := rows.Scan(&claim.Name, &claim.UserID, &claim.UserName, &claim.OptedOut); err != nil {
+				return err
+			}
+			claims = append(claims, claim)
+		}
+		return nil
+	}, "SELECT name, user_id, user_name, opted_out FROM quote_identities "+
+		"WHERE group_id=$1 AND NOT verified ORDER BY name", id)
+	return claims, err
+}
+
+// Approves a pending claim. Returns ErrNotFound if nobody is waiting on the name
+func (d *MemeDB) VerifyClaim(groupID string, name string) (claim Claim, err error) {
+	id, err := parseGroupID(groupID)
+	if err != nil {
+		return claim, err
+	}
+	err = d.withWriteRetry("VerifyClaim", func(ctx context.Context) error {
+		return d.db.QueryRowContext(ctx, "UPDATE quote_identities SET verified=TRUE "+
+			"WHERE group_id=$1 AND name=$2 AND NOT verified RETURNING name, user_id, user_name, opted_out",
+			id, strings.ToLower(name)).Scan(&claim.Name, &claim.UserID, &claim.UserName, &claim.OptedOut)
+	})
+	if err != nil {
+		return claim, err
+	}
+	claim.Verified = true
+	return claim, nil
+}
+
+// Removes whatever claim there is on a name, so the right person can
+// claim it. Returns ErrNotFound if the name isn't claimed
+func (d *MemeDB) DeleteClaim(groupID string, name string) error {
+	id, err := parseGroupID(groupID)
+	if err != nil {
+		return err
+	}
+	result, err := d.exec("DeleteClaim", "DELETE FROM quote_identities WHERE group_id=$1 AND name=$2",
+		id, strings.ToLower(name))
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Gets the verified quote names a user has linked to themselves
+func (d *MemeDB) GetLinkedNames(groupID string, userID string) (names []string, err error) {
+	id, err := parseGroupID(groupID)
+	if err != nil {
+		return nil, err
+	}
+	err = d.query("GetLinkedNames", func(rows *sql.Rows) error {
+		names = nil
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				return err
+			}
+			names = append(names, name)
+		}
+		return nil
+	}, "SELECT name FROM quote_identities WHERE group_id=$1 AND user_id=$2 AND verified ORDER BY name", id, userID)
+	if err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// Which quotes forgetting a user removes: the ones filed under their
+// verified names, and if submissions is set the ones they recorded
+const forgetCondition = "group_id=$1 AND (lower(name) IN (SELECT name FROM quote_identities " +
+	"WHERE group_id=$1 AND user_id=$2 AND verified) OR ($3 AND submit_by=$2))"
+
+// Counts the quotes ForgetUser would delete
+func (d *MemeDB) CountForgettable(groupID string, userID string, submissions bool) (count int, err error) {
+	id, err := parseGroupID(groupID)
+	if err != nil {
+		return 0, err
+	}
+	err = d.queryRow("CountForgettable", "SELECT COUNT(*) FROM quotes WHERE "+forgetCondition,
+		[]interface{}{id, userID, submissions}, &count)
+	return count, err
+}
+
+// Deletes every quote about a user, and if submissions is set every quote
+// they recorded. Returns how many were deleted
+func (d *MemeDB) ForgetUser(groupID string, userID string, submissions bool) (deleted int, err error) {
+	id, err := parseGroupID(groupID)
+	if err != nil {
+		return 0, err
+	}
+	var names []string
+	err = d.transaction("ForgetUser", func(ctx context.Context, tx *sql.Tx) error {
+		names = nil
+		rows, err := tx.QueryContext(ctx, "DELETE FROM quotes WHERE "+forgetCondition+" RETURNING name",
+			id, userID, submissions)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				return err
+			}
+			names = append(names, name)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return 0, err
+	}
+	notified := make(map[string]bool)
+	for _, name := range names {
+		if !notified[name] {
+			notified[name] = true
+			d.notifyChange(groupID, name)
+		}
+	}
+	return len(names), nil
+}
+
+// Records an action taken on someone's quotes or privacy settings
+func (d *MemeDB) WriteAudit(groupID string, userID string, action string, detail string) error {
+	id, err := parseGroupID(groupID)
+	if err != nil {
+		return err
+	}
+	_, err = d.exec("WriteAudit", "INSERT INTO audit_log (group_id, user_id, action, detail) VALUES ($1, $2, $3, $4)",
+		id, userID, action, detail)
+	return err
+}
@@ -0,0 +1,261 @@
+package adapter
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Returned by WriteUserQuote when the database couldn't be reached and
+// the quote was put in the write queue instead. It will be written once
+// the database is back.
+var ErrQueued = errors.New("quote queued until the database is available")
+
+// Replays a queued write may fail with the database up before it's
+// treated as one that will never get through
+const maxReplayAttempts = 5
+
+// A quote write waiting in the queue
+type queuedQuote struct {
+	// generated when the quote is first written so a replay that already
+	// made it to the database isn't inserted twice
+	Key     string `json:"key"`
+	Name    string `json:"name,omitempty"`
+	Quote   string `json:"quote,omitempty"`
+	GroupID string `json:"group_id,omitempty"`
+	// RFC 3339 in UTC
+	Date          string `json:"date,omitempty"`
+	Submitter     string `json:"submit_by,omitempty"`
+	SubmitterName string `json:"submit_name,omitempty"`
+	// empty for approved
+	Status string `json:"status,omitempty"`
+	// marks the write with this key as synced
+	Done bool `json:"done,omitempty"`
+}
+
+// Parses the entry's date. Entries queued before dates had times in them
+// only have the day
+func (q queuedQuote) date() (time.Time, error) {
+	if date, err := time.Parse(time.RFC3339, q.Date); err == nil {
+		return date, nil
+	}
+	return time.Parse("2006-01-02", strings.TrimSpace(q.Date))
+}
+
+// Append-only file of quote writes made while the database was down
+type writeQueue struct {
+	// guards the file and pending. Never held while talking to the database
+	sync.Mutex
+	// held for a whole replay so two don't run at once
+	replaying sync.Mutex
+	path      string
+	file      *os.File
+	pending   []queuedQuote
+	// failed replays by key, only touched while replaying. Starts over
+	// when the bot restarts
+	attempts map[string]int
+	log      *logrus.Logger
+}
+
+// Creates a random idempotency key
+func newQuoteKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Opens the queue file, loading any writes that haven't been synced yet
+func openWriteQueue(path string) (*writeQueue, error) {
+	q := &writeQueue{path: path, attempts: make(map[string]int)}
+	if err := q.load(); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	q.file = file
+	return q, nil
+}
+
+func (q *writeQueue) load() error {
+	file, err := os.Open(q.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	done := make(map[string]bool)
+	var entries []queuedQuote
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry queuedQuote
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// a line cut short by a crash. Everything before it is still good
+			continue
+		}
+		if entry.Done {
+			done[entry.Key] = true
+		} else {
+			entries = append(entries, entry)
+		}
+	}
+	for _, entry := range entries {
+		if !done[entry.Key] {
+			q.pending = append(q.pending, entry)
+		}
+	}
+	return scanner.Err()
+}
+
+// Writes an entry to the end of the file and flushes it to disk
+func (q *writeQueue) append(entry queuedQuote) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err = q.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return q.file.Sync()
+}
+
+func (q *writeQueue) push(entry queuedQuote) error {
+	q.Lock()
+	defer q.Unlock()
+	if err := q.append(entry); err != nil {
+		return err
+	}
+	q.pending = append(q.pending, entry)
+	return nil
+}
+
+// Starts the file over once everything in it has been synced
+func (q *writeQueue) compact() error {
+	if len(q.pending) > 0 {
+		return nil
+	}
+	if err := q.file.Truncate(0); err != nil {
+		return err
+	}
+	_, err := q.file.Seek(0, 0)
+	return err
+}
+
+// Sets up a file-backed queue that takes quote writes while the
+// database is unreachable, and replays them in order every interval
+// once it can be reached again. Writes that can never succeed are logged
+// and set aside in the dead letter table.
+func (d *MemeDB) UseWriteQueue(path string, interval time.Duration, log *logrus.Logger) error {
+	q, err := openWriteQueue(path)
+	if err != nil {
+		return err
+	}
+	q.log = log
+	d.queue = q
+	go func() {
+		for range time.Tick(interval) {
+			_ = d.ReplayQueue()
+		}
+	}()
+	return nil
+}
+
+// Number of quote writes waiting for the database
+func (d *MemeDB) QueueLength() int {
+	if d.queue == nil {
+		return 0
+	}
+	d.queue.Lock()
+	defer d.queue.Unlock()
+	return len(d.queue.pending)
+}
+
+// Writes queued quotes to the database in the order they were made.
+// Stops when the database can't be reached so later writes don't jump
+// ahead. Writes failing for any other reason would never get through, so
+// they go to the dead letter table instead of holding up the rest, as do
+// writes that keep timing out after maxReplayAttempts replays.
+func (d *MemeDB) ReplayQueue() error {
+	if d.queue == nil {
+		return nil
+	}
+	q := d.queue
+	q.replaying.Lock()
+	defer q.replaying.Unlock()
+
+	// records keep queueing while this runs, so only hold the lock to copy.
+	// Nothing else takes entries off the front
+	q.Lock()
+	entries := append([]queuedQuote(nil), q.pending...)
+	q.Unlock()
+	if len(entries) == 0 {
+		return nil
+	}
+	if err := d.Ping(); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		_, err := d.insertQuote(entry)
+		if errors.Is(err, ErrUnavailable) {
+			// the ping got through, so this write may be what's failing
+			q.attempts[entry.Key]++
+			if q.attempts[entry.Key] < maxReplayAttempts {
+				return err
+			}
+		}
+		if err != nil {
+			if dlErr := d.deadLetter(entry, err); dlErr != nil {
+				return dlErr
+			}
+			if q.log != nil {
+				q.log.WithFields(logrus.Fields{
+					"err":   err.Error(),
+					"key":   entry.Key,
+					"group": entry.GroupID,
+					"name":  entry.Name,
+				}).Error("Queued quote can't be written. Moved it to the dead letter table")
+			}
+		}
+
+		q.Lock()
+		doneErr := q.append(queuedQuote{Key: entry.Key, Done: true})
+		if doneErr == nil {
+			q.pending = q.pending[1:]
+			delete(q.attempts, entry.Key)
+		}
+		q.Unlock()
+		if doneErr != nil {
+			return doneErr
+		}
+		if err == nil {
+			d.notifyChange(entry.GroupID, entry.Name)
+		}
+	}
+	q.Lock()
+	defer q.Unlock()
+	return q.compact()
+}
+
+// Sets aside a queued write that failed for good, with why
+func (d *MemeDB) deadLetter(entry queuedQuote, cause error) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = d.execIdempotent("DeadLetterQuote", "INSERT INTO quote_dead_letters (key, entry, error) VALUES ($1, $2, $3) "+
+		"ON CONFLICT (key) DO NOTHING", entry.Key, string(data), cause.Error())
+	return err
+}
@@ -0,0 +1,272 @@
+package adapter
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// A database/sql driver that hands every statement to the fakeDB of its
+// connection string, so the queue can be replayed without Postgres
+var fakeDBs = struct {
+	sync.Mutex
+	dbs map[string]*fakeDB
+}{dbs: make(map[string]*fakeDB)}
+
+func init() {
+	sql.Register("queuetest", fakeDriver{})
+}
+
+// What the fake database does with quote inserts
+type fakeDB struct {
+	sync.Mutex
+	// returned by pings when set
+	down error
+	// returned by inserts of the quote with that text
+	failures map[string]error
+	// quotes inserted and dead lettered, in order
+	inserted     []string
+	deadLettered []string
+}
+
+func (f *fakeDB) insert(args []driver.NamedValue) ([]driver.Value, error) {
+	f.Lock()
+	defer f.Unlock()
+	quote := args[1].Value.(string)
+	if err := f.failures[quote]; err != nil {
+		return nil, err
+	}
+	f.inserted = append(f.inserted, quote)
+	return []driver.Value{int64(len(f.inserted))}, nil
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	fakeDBs.Lock()
+	defer fakeDBs.Unlock()
+	return fakeConn{fakeDBs.dbs[name]}, nil
+}
+
+type fakeConn struct{ db *fakeDB }
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c fakeConn) Close() error                              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)                 { return nil, errors.New("not supported") }
+
+func (c fakeConn) Ping(ctx context.Context) error {
+	c.db.Lock()
+	defer c.db.Unlock()
+	return c.db.down
+}
+
+func (c fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if !strings.HasPrefix(query, "WITH inserted AS (INSERT INTO quotes") {
+		return nil, errors.New("unexpected query: " + query)
+	}
+	row, err := c.db.insert(args)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{row: row}, nil
+}
+
+func (c fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if !strings.HasPrefix(query, "INSERT INTO quote_dead_letters") {
+		return nil, errors.New("unexpected statement: " + query)
+	}
+	var entry queuedQuote
+	if err := json.Unmarshal([]byte(args[1].Value.(string)), &entry); err != nil {
+		return nil, err
+	}
+	c.db.Lock()
+	c.db.deadLettered = append(c.db.deadLettered, entry.Quote)
+	c.db.Unlock()
+	return driver.RowsAffected(1), nil
+}
+
+// The one row of an insert's RETURNING id
+type fakeRows struct {
+	row  []driver.Value
+	done bool
+}
+
+func (r *fakeRows) Columns() []string { return []string{"id"} }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	copy(dest, r.row)
+	return nil
+}
+
+// Opens a MemeDB on a fake database with its write queue in a temp file
+// holding the given lines
+func newQueueTestDB(t *testing.T, lines ...string) (*MemeDB, *fakeDB, string) {
+	t.Helper()
+	fake := &fakeDB{failures: make(map[string]error)}
+	fakeDBs.Lock()
+	fakeDBs.dbs[t.Name()] = fake
+	fakeDBs.Unlock()
+	db, err := sql.Open("queuetest", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	path := filepath.Join(t.TempDir(), "queue.jsonl")
+	if len(lines) > 0 {
+		if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	q, err := openWriteQueue(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { q.file.Close() })
+	d := &MemeDB{db: db, queue: q, options: DBOptions{MaxRetries: -1, RetryBackoff: time.Millisecond}.withDefaults()}
+	return d, fake, path
+}
+
+// A line of the queue file
+func queueLine(t *testing.T, entry queuedQuote) string {
+	t.Helper()
+	if entry.GroupID == "" && !entry.Done {
+		entry.GroupID = "1234"
+		entry.Name = "ethan"
+		entry.Date = "2019-05-01T17:30:00Z"
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(line)
+}
+
+func TestLoadWriteQueue(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		want  []string
+	}{
+		{"empty", nil, nil},
+		{"in order", []string{`{"key":"a","quote":"one"}`, `{"key":"b","quote":"two"}`}, []string{"one", "two"}},
+		{"synced ones dropped", []string{`{"key":"a","quote":"one"}`, `{"key":"b","quote":"two"}`, `{"key":"a","done":true}`},
+			[]string{"two"}},
+		{"cut short by a crash", []string{`{"key":"a","quote":"one"}`, `{"key":"b","quo`}, []string{"one"}},
+		{"garbage in the middle", []string{`{"key":"a","quote":"one"}`, "\x00\x00\x00", `not json`, `{"key":"b","quote":"two"}`},
+			[]string{"one", "two"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			d, _, _ := newQueueTestDB(t, tc.lines...)
+			var got []string
+			for _, entry := range d.queue.pending {
+				got = append(got, entry.Quote)
+			}
+			if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+				t.Errorf("pending quotes are %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestReplayAfterRestart(t *testing.T) {
+	// left behind by a run that stopped while the database was down
+	d, fake, path := newQueueTestDB(t,
+		queueLine(t, queuedQuote{Key: "a", Quote: "synced before the restart"}),
+		queueLine(t, queuedQuote{Key: "b", Quote: "one"}),
+		queueLine(t, queuedQuote{Key: "a", Done: true}),
+		queueLine(t, queuedQuote{Key: "c", Quote: "two"}),
+		`{"key":"d","quote":"thr`)
+	var changed []string
+	d.AddChangeListener(func(groupID string, name string) {
+		changed = append(changed, groupID+"/"+name)
+	})
+
+	if err := d.ReplayQueue(); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(fake.inserted, ",") != "one,two" {
+		t.Errorf("inserted %v, want [one two]", fake.inserted)
+	}
+	if len(changed) != 2 {
+		t.Errorf("change listeners called for %v, want both quotes", changed)
+	}
+	if d.QueueLength() != 0 {
+		t.Errorf("%d quotes still queued", d.QueueLength())
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() != 0 {
+		t.Errorf("queue file wasn't emptied: %v", err)
+	}
+}
+
+func TestReplayDatabaseDown(t *testing.T) {
+	d, fake, _ := newQueueTestDB(t, queueLine(t, queuedQuote{Key: "a", Quote: "one"}))
+	fake.down = driver.ErrBadConn
+	for n := 0; n < maxReplayAttempts+1; n++ {
+		if err := d.ReplayQueue(); !errors.Is(err, ErrUnavailable) {
+			t.Fatalf("replay %d: err is %v, want ErrUnavailable", n, err)
+		}
+	}
+	// an outage, however long, doesn't count against the quote
+	if d.QueueLength() != 1 || len(fake.deadLettered) != 0 {
+		t.Errorf("%d queued and %v dead lettered, want the quote still queued", d.QueueLength(), fake.deadLettered)
+	}
+}
+
+func TestReplayDeadLetters(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		// replays that return an error before the quote is set aside
+		failedReplays int
+	}{
+		{"permanent error", &pq.Error{Code: "23502"}, 0},
+		{"keeps timing out", driver.ErrBadConn, maxReplayAttempts - 1},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			d, fake, _ := newQueueTestDB(t,
+				queueLine(t, queuedQuote{Key: "a", Quote: "bad"}),
+				queueLine(t, queuedQuote{Key: "b", Quote: "good"}))
+			fake.failures["bad"] = tc.err
+
+			for n := 0; n < tc.failedReplays; n++ {
+				if err := d.ReplayQueue(); err == nil {
+					t.Fatalf("replay %d succeeded", n)
+				}
+				if d.QueueLength() != 2 || len(fake.inserted) != 0 {
+					t.Fatalf("replay %d: %d queued and %v inserted, want the queue held up", n, d.QueueLength(), fake.inserted)
+				}
+			}
+			if err := d.ReplayQueue(); err != nil {
+				t.Fatalf("last replay: %v", err)
+			}
+			if strings.Join(fake.deadLettered, ",") != "bad" {
+				t.Errorf("dead lettered %v, want [bad]", fake.deadLettered)
+			}
+			if strings.Join(fake.inserted, ",") != "good" {
+				t.Errorf("inserted %v, want [good]", fake.inserted)
+			}
+			if d.QueueLength() != 0 {
+				t.Errorf("%d quotes still queued", d.QueueLength())
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package adapter
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"expvar"
+	"net"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Settings for the connection pool and for how queries are run.
+// Zero values are replaced with the defaults below.
+type DBOptions struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	// deadline for a single query, including reading its rows
+	QueryTimeout time.Duration
+	// times a query is retried after a transient error
+	MaxRetries int
+	// most time a call may spend on attempts and backoff altogether
+	MaxRetryTime time.Duration
+	// wait before the first retry. Doubles on every retry after it
+	RetryBackoff time.Duration
+	// people whose quotes are kept in memory. Negative turns the cache off
+	CacheSize int
+	// how long cached quotes are used before being loaded again
+	CacheTTL time.Duration
+}
+
+const (
+	defaultMaxOpenConns    = 10
+	defaultMaxIdleConns    = 5
+	defaultConnMaxLifetime = 30 * time.Minute
+	defaultQueryTimeout    = 5 * time.Second
+	defaultMaxRetries      = 3
+	defaultMaxRetryTime    = 8 * time.Second
+	defaultRetryBackoff    = 100 * time.Millisecond
+	defaultCacheSize       = 256
+	defaultCacheTTL        = 10 * time.Minute
+)
+
+func (o DBOptions) withDefaults() DBOptions {
+	if o.MaxOpenConns <= 0 {
+		o.MaxOpenConns = defaultMaxOpenConns
+	}
+	if o.MaxIdleConns <= 0 {
+		o.MaxIdleConns = defaultMaxIdleConns
+	}
+	if o.ConnMaxLifetime <= 0 {
+		o.ConnMaxLifetime = defaultConnMaxLifetime
+	}
+	if o.QueryTimeout <= 0 {
+		o.QueryTimeout = defaultQueryTimeout
+	}
+	if o.MaxRetries < 0 {
+		o.MaxRetries = 0
+	} else if o.MaxRetries == 0 {
+		o.MaxRetries = defaultMaxRetries
+	}
+	if o.MaxRetryTime <= 0 {
+		o.MaxRetryTime = defaultMaxRetryTime
+	}
+	if o.RetryBackoff <= 0 {
+		o.RetryBackoff = defaultRetryBackoff
+	}
+	if o.CacheSize == 0 {
+		o.CacheSize = defaultCacheSize
+	}
+	if o.CacheTTL <= 0 {
+		o.CacheTTL = defaultCacheTTL
+	}
+	return o
+}
+
+// Returns true for errors worth retrying: dropped connections, timeouts
+// and postgres telling us to try again later
+func isTransient(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Class() {
+		// connection exception, insufficient resources
+		case "08", "53":
+			return true
+		}
+		switch pqErr.Code {
+		// serialization failure, deadlock, cannot connect now
+		case "40001", "40P01", "57P03":
+			return true
+		}
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// Returns true if a failed attempt can't have changed anything: the
+// server turned it down or a connection was never made. Writes that
+// would apply twice are only retried after these
+func notApplied(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) {
+		// drivers only return this before the statement is sent
+		return true
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// the server failed the statement, so it was rolled back
+		return isTransient(err)
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// Runs fn with a query deadline, retrying with backoff while it fails
+// with transient errors. Only for reads and for writes that come out the
+// same however many times they run. Errors are wrapped in a DBError for
+// the operation
+func (d *MemeDB) withRetry(op string, fn func(ctx context.Context) error) error {
+	return d.retry(op, isTransient, fn)
+}
+
+// Like withRetry, for writes that would apply twice if repeated. An
+// attempt that timed out or lost its connection may have committed, so
+// those failures go straight back to the caller
+func (d *MemeDB) withWriteRetry(op string, fn func(ctx context.Context) error) error {
+	return d.retry(op, notApplied, fn)
+}
+
+func (d *MemeDB) retry(op string, retryable func(error) bool, fn func(ctx context.Context) error) (err error) {
+	start := time.Now()
+	defer func() { observeQuery(op, start, err) }()
+	// callers may hold locks, so a slow database can't keep them waiting
+	// past the retry budget
+	deadline := start.Add(d.options.MaxRetryTime)
+	backoff := d.options.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		timeout := d.options.QueryTimeout
+		if remaining := time.Until(deadline); remaining < timeout {
+			timeout = remaining
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		err = fn(ctx)
+		cancel()
+		if err == nil || !retryable(err) || attempt >= d.options.MaxRetries ||
+			time.Until(deadline) <= backoff {
+			return wrapErr(op, err)
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// Runs a statement that doesn't return rows. It's only retried if the
+// failed attempt can't have been applied
+func (d *MemeDB) exec(op string, query string, args ...interface{}) (result sql.Result, err error) {
+	err = d.withWriteRetry(op, func(ctx context.Context) error {
+		result, err = d.db.ExecContext(ctx, query, args...)
+		return err
+	})
+	return
+}
+
+// Runs a statement that comes out the same however many times it runs,
+// like an upsert of fixed values, retrying any transient failure
+func (d *MemeDB) execIdempotent(op string, query string, args ...interface{}) (result sql.Result, err error) {
+	err = d.withRetry(op, func(ctx context.Context) error {
+		result, err = d.db.ExecContext(ctx, query, args...)
+		return err
+	})
+	return
+}
+
+// Runs a query and hands its rows to scan. The rows are closed
+// afterwards. scan may be called again if the query is retried, so it
+// should start its results over each time.
+func (d *MemeDB) query(op string, scan func(rows *sql.Rows) error, query string, args ...interface{}) error {
+	return d.withRetry(op, func(ctx context.Context) error {
+		rows, err := d.db.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		if err := scan(rows); err != nil {
+			return err
+		}
+		return rows.Err()
+	})
+}
+
+// Runs a query expected to return at most one row and scans it into dest.
+// Returns ErrNotFound if there wasn't a row
+func (d *MemeDB) queryRow(op string, query string, args []interface{}, dest ...interface{}) error {
+	return d.withRetry(op, func(ctx context.Context) error {
+		return d.db.QueryRowContext(ctx, query, args...).Scan(dest...)
+	})
+}
+
+// Runs fn in a transaction, committing if it returns nil. It's only
+// retried if the failed attempt can't have been committed
+func (d *MemeDB) transaction(op string, fn func(ctx context.Context, tx *sql.Tx) error) error {
+	return d.withWriteRetry(op, func(ctx context.Context) error {
+		tx, err := d.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if err := fn(ctx, tx); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+		return tx.Commit()
+	})
+}
+
+// Checks that the database can be reached
+func (d *MemeDB) Ping() error {
+	return d.withRetry("Ping", func(ctx context.Context) error {
+		return d.db.PingContext(ctx)
+	})
+}
+
+// Gets statistics about the connection pool
+func (d *MemeDB) Stats() sql.DBStats {
+	return d.db.Stats()
+}
+
+// Publishes the pool and cache statistics through expvar under the given name
+func (d *MemeDB) PublishStats(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return map[string]interface{}{
+			"pool":  d.Stats(),
+			"cache": d.CacheStats(),
+		}
+	}))
+}
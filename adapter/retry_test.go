@@ -0,0 +1,135 @@
+package adapter
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// A net.Error that isn't an OpError, like the ones a TLS conn returns
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestRetryableErrors(t *testing.T) {
+	dialErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	readErr := &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}
+	tests := []struct {
+		name       string
+		err        error
+		transient  bool
+		notApplied bool
+	}{
+		{"bad conn", driver.ErrBadConn, true, true},
+		{"wrapped bad conn", fmt.Errorf("query: %w", driver.ErrBadConn), true, true},
+		{"deadline", context.DeadlineExceeded, true, false},
+		{"connection exception", &pq.Error{Code: "08006"}, true, true},
+		{"too many connections", &pq.Error{Code: "53300"}, true, true},
+		{"serialization failure", &pq.Error{Code: "40001"}, true, true},
+		{"deadlock", &pq.Error{Code: "40P01"}, true, true},
+		{"starting up", &pq.Error{Code: "57P03"}, true, true},
+		{"unique violation", &pq.Error{Code: "23505"}, false, false},
+		{"syntax error", &pq.Error{Code: "42601"}, false, false},
+		{"dial", dialErr, true, true},
+		{"read", readErr, true, false},
+		{"timeout", timeoutError{}, true, false},
+		{"no rows", sql.ErrNoRows, false, false},
+		{"canceled", context.Canceled, false, false},
+		{"other", errors.New("boom"), false, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := isTransient(tc.err); got != tc.transient {
+				t.Errorf("isTransient = %v, want %v", got, tc.transient)
+			}
+			if got := notApplied(tc.err); got != tc.notApplied {
+				t.Errorf("notApplied = %v, want %v", got, tc.notApplied)
+			}
+		})
+	}
+}
+
+func TestRetry(t *testing.T) {
+	options := DBOptions{
+		QueryTimeout: time.Second,
+		MaxRetries:   3,
+		MaxRetryTime: time.Second,
+		RetryBackoff: time.Millisecond,
+	}.withDefaults()
+	tests := []struct {
+		name      string
+		write     bool
+		errs      []error
+		wantCalls int
+		wantKind  error
+	}{
+		{"succeeds", false, nil, 1, nil},
+		{"read retried after timeout", false, []error{context.DeadlineExceeded}, 2, nil},
+		{"write not retried after timeout", true, []error{context.DeadlineExceeded, nil}, 1, ErrUnavailable},
+		{"write retried after bad conn", true, []error{driver.ErrBadConn}, 2, nil},
+		{"permanent error", false, []error{&pq.Error{Code: "23505"}, nil}, 1, ErrConstraint},
+		{"gives up", false, []error{driver.ErrBadConn, driver.ErrBadConn, driver.ErrBadConn, driver.ErrBadConn, nil},
+			4, ErrUnavailable},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			d := &MemeDB{options: options}
+			calls := 0
+			fn := func(ctx context.Context) error {
+				calls++
+				if calls <= len(tc.errs) {
+					return tc.errs[calls-1]
+				}
+				return nil
+			}
+			var err error
+			if tc.write {
+				err = d.withWriteRetry("Test", fn)
+			} else {
+				err = d.withRetry("Test", fn)
+			}
+			if calls != tc.wantCalls {
+				t.Errorf("called %d times, want %d", calls, tc.wantCalls)
+			}
+			if tc.wantKind == nil && err != nil {
+				t.Errorf("err is %v, want nil", err)
+			} else if tc.wantKind != nil && !errors.Is(err, tc.wantKind) {
+				t.Errorf("err is %v, want %v", err, tc.wantKind)
+			}
+		})
+	}
+}
+
+func TestRetryTimeCap(t *testing.T) {
+	d := &MemeDB{options: DBOptions{
+		QueryTimeout: time.Second,
+		MaxRetries:   100,
+		MaxRetryTime: 50 * time.Millisecond,
+		RetryBackoff: 10 * time.Millisecond,
+	}.withDefaults()}
+	start := time.Now()
+	var lastDeadline time.Duration
+	err := d.withRetry("Test", func(ctx context.Context) error {
+		deadline, _ := ctx.Deadline()
+		lastDeadline = time.Until(deadline)
+		return driver.ErrBadConn
+	})
+	if !errors.Is(err, ErrUnavailable) {
+		t.Errorf("err is %v, want ErrUnavailable", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("retried for %s, want it capped near 50ms", elapsed)
+	}
+	if lastDeadline > 50*time.Millisecond {
+		t.Errorf("attempt deadline was %s past the retry budget", lastDeadline)
+	}
+}
@@ -0,0 +1,119 @@
+package adapter
+
+// Tables used by the bots besides the original quotes table. Each statement
+// is safe to run on every startup.
+var schema = []string{
+	// quotes used to only store the day they were said
+	`DO $$ BEGIN
+		IF (SELECT data_type FROM information_schema.columns
+			WHERE table_name='quotes' AND column_name='date') = 'date' THEN
+			ALTER TABLE quotes ALTER COLUMN date TYPE TIMESTAMPTZ USING date::timestamp AT TIME ZONE 'UTC';
+		END IF;
+	END $$`,
+	// lets replayed writes from the write queue be recognized
+	`ALTER TABLE quotes ADD COLUMN IF NOT EXISTS idempotency_key TEXT UNIQUE`,
+	`ALTER TABLE quotes ADD COLUMN IF NOT EXISTS submit_name TEXT`,
+	// quotes in moderated groups wait as pending until approved
+	`ALTER TABLE quotes ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'approved'`,
+	`ALTER TABLE quotes ADD COLUMN IF NOT EXISTS reject_reason TEXT`,
+	`ALTER TABLE quotes ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}'`,
+	// queued quote writes that failed for a reason other than the database being down
+	`CREATE TABLE IF NOT EXISTS quote_dead_letters (
+		key TEXT PRIMARY KEY,
+		entry TEXT NOT NULL,
+		error TEXT NOT NULL,
+		failed TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`CREATE TABLE IF NOT EXISTS dashboard_tokens (
+		token TEXT PRIMARY KEY,
+		group_id BIGINT NOT NULL,
+		user_id TEXT NOT NULL,
+		expires TIMESTAMPTZ NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS group_settings (
+		group_id BIGINT NOT NULL,
+		setting TEXT NOT NULL,
+		value TEXT NOT NULL,
+		PRIMARY KEY (group_id, setting)
+	)`,
+	`CREATE TABLE IF NOT EXISTS quote_votes (
+		quote_id BIGINT NOT NULL REFERENCES quotes (id) ON DELETE CASCADE,
+		user_id TEXT NOT NULL,
+		vote SMALLINT NOT NULL,
+		PRIMARY KEY (quote_id, user_id)
+	)`,
+	// posts the bot made of quotes, so likes on them count towards the quote
+	`CREATE TABLE IF NOT EXISTS quote_messages (
+		message_id TEXT PRIMARY KEY,
+		quote_id BIGINT NOT NULL REFERENCES quotes (id) ON DELETE CASCADE,
+		group_id BIGINT NOT NULL,
+		posted TIMESTAMPTZ NOT NULL DEFAULT now(),
+		likes INT NOT NULL DEFAULT 0
+	)`,
+	// names members have claimed as their own, and whether they can be quoted
+	`CREATE TABLE IF NOT EXISTS quote_identities (
+		group_id BIGINT NOT NULL,
+		name TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		opted_out BOOLEAN NOT NULL DEFAULT FALSE,
+		PRIMARY KEY (group_id, name)
+	)`,
+	// claims only count once the name matched the claimant or an admin
+	// approved it. Claims from before that need approving again
+	`ALTER TABLE quote_identities ADD COLUMN IF NOT EXISTS verified BOOLEAN NOT NULL DEFAULT FALSE`,
+	`ALTER TABLE quote_identities ADD COLUMN IF NOT EXISTS user_name TEXT NOT NULL DEFAULT ''`,
+	`CREATE TABLE IF NOT EXISTS audit_log (
+		id BIGSERIAL PRIMARY KEY,
+		group_id BIGINT NOT NULL,
+		user_id TEXT NOT NULL,
+		action TEXT NOT NULL,
+		detail TEXT NOT NULL,
+		at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`CREATE TABLE IF NOT EXISTS group_features (
+		group_id BIGINT NOT NULL,
+		feature TEXT NOT NULL,
+		enabled BOOLEAN NOT NULL,
+		PRIMARY KEY (group_id, feature)
+	)`,
+	`CREATE TABLE IF NOT EXISTS feature_stages (
+		feature TEXT PRIMARY KEY,
+		stage TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS c4_games (
+		group_id BIGINT PRIMARY KEY,
+		board TEXT NOT NULL,
+		red_id TEXT NOT NULL,
+		red_name TEXT NOT NULL,
+		yellow_id TEXT NOT NULL,
+		yellow_name TEXT NOT NULL,
+		red_turn BOOLEAN NOT NULL,
+		last_move TIMESTAMPTZ NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS c4_stats (
+		group_id BIGINT NOT NULL,
+		user_id TEXT NOT NULL,
+		name TEXT NOT NULL,
+		wins INT NOT NULL DEFAULT 0,
+		losses INT NOT NULL DEFAULT 0,
+		draws INT NOT NULL DEFAULT 0,
+		PRIMARY KEY (group_id, user_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS trivia_scores (
+		group_id BIGINT NOT NULL,
+		user_id TEXT NOT NULL,
+		name TEXT NOT NULL,
+		points INT NOT NULL DEFAULT 0,
+		PRIMARY KEY (group_id, user_id)
+	)`,
+}
+
+// Creates any missing tables the bots rely on
+func (d *MemeDB) CreateTables() error {
+	for _, stmt := range schema {
+		if _, err := d.execIdempotent("CreateTables", stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+package adapter
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ethanzeigler/groupme/gmbots/filter"
+	"github.com/lib/pq"
+)
+
+// Narrows down SearchQuotes. Zero values don't filter anything
+type QuoteFilter struct {
+	// exact name the quotes are filed under, ignoring case
+	Name string
+	// text the quote contains, ignoring case
+	Text string
+	Tag  string
+	// quotes said at or after From and before To
+	From time.Time
+	To   time.Time
+	// paging. Limit defaults to 50
+	Offset int
+	Limit  int
+}
+
+const defaultSearchLimit = 50
+
+// Builds the WHERE clause for a filter. The group ID is always $1
+func (f QuoteFilter) where(groupID uint64) (string, []interface{}) {
+	conditions := []string{"group_id=$1", "status='approved'"}
+	args := []interface{}{groupID}
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if f.Name != "" {
+		add("lower(name)=lower($%d)", f.Name)
+	}
+	if f.Text != "" {
+		add("quote ILIKE ('%%' || $%d || '%%')", escapeLike(f.Text))
+	}
+	if f.Tag != "" {
+		add("$%d = ANY(tags)", strings.ToLower(f.Tag))
+	}
+	if !f.From.IsZero() {
+		add("date >= $%d", f.From)
+	}
+	if !f.To.IsZero() {
+		add("date < $%d", f.To)
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// Escapes the wildcards in text matched with LIKE
+func escapeLike(text string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(text)
+}
+
+// Gets a page of the group's quotes matching the filter, newest first,
+// along with how many match in total
+func (d *MemeDB) SearchQuotes(groupID string, search QuoteFilter) (quotes []Quote, total int, err error) {
+	id, err := parseGroupID(groupID)
+	if err != nil {
+		return nil, 0, err
+	}
+	if search.Limit <= 0 {
+		search.Limit = defaultSearchLimit
+	}
+	if search.Offset < 0 {
+		search.Offset = 0
+	}
+	where, args := search.where(id)
+	err = d.withRetry("SearchQuotes", func(ctx context.Context) error {
+		if err := d.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM quotes WHERE "+where, args...).Scan(&total); err != nil {
+			return err
+		}
+		rows, err := d.db.QueryContext(ctx, "SELECT "+quoteColumns+" FROM quotes WHERE "+where+
+			fmt.Sprintf(" ORDER BY date DESC, id DESC LIMIT %d OFFSET %d", search.Limit, search.Offset), args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		quotes = nil
+		for rows.Next() {
+			quote, err := scanQuote(rows)
+			if err != nil {
+				return err
+			}
+			quotes = append(quotes, quote)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return quotes, total, nil
+}
+
+// Changes the name, text and tags of a quote. The text goes through the
+// content filter the same way new quotes do. Returns ErrNotFound if the
+// group has no such approved quote, so held quotes only change through
+// moderation, and ErrOptedOut if it's being moved to someone
+// who opted out
+func (d *MemeDB) UpdateQuote(groupID string, id uint64, name string, quote string, tags []string) (Quote, error) {
+	group, err := parseGroupID(groupID)
+	if err != nil {
+		return Quote{}, err
+	}
+	if d.filter != nil {
+		result := filter.Apply(d.filter, d.policies[groupID], quote)
+		if result.Blocked {
+			return Quote{}, ErrFiltered
+		}
+		quote = result.Text
+	}
+	cleaned := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if tag = strings.ToLower(strings.TrimSpace(tag)); tag != "" {
+			cleaned = append(cleaned, tag)
+		}
+	}
+
+	var old string
+	var updated Quote
+	err = d.transaction("UpdateQuote", func(ctx context.Context, tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, "SELECT name FROM quotes WHERE id=$1 AND group_id=$2 AND status='approved' FOR UPDATE",
+			id, group).Scan(&old)
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(old, name) {
+			// renaming files the quote under someone new, so they get the
+			// same say as when it's recorded
+			var optedOut bool
+			err = tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM quote_identities "+
+				"WHERE group_id=$1 AND name=lower($2) AND opted_out AND verified)", group, name).Scan(&optedOut)
+			if err != nil {
+				return err
+			} else if optedOut {
+				return ErrOptedOut
+			}
+		}
+		updated, err = scanQuote(tx.QueryRowContext(ctx, "UPDATE quotes SET name=$3, quote=$4, tags=$5 "+
+			"WHERE id=$1 AND group_id=$2 AND status='approved' RETURNING "+quoteColumns, id, group, name, quote, pq.Array(cleaned)))
+		return err
+	})
+	if err != nil {
+		return Quote{}, err
+	}
+	d.notifyChange(groupID, old)
+	if !strings.EqualFold(old, name) {
+		d.notifyChange(groupID, name)
+	}
+	return updated, nil
+}
+
+// Summary of a group's quotes
+type QuoteStats struct {
+	Quotes int
+	People int
+	// quote count by name, most quoted first
+	ByName []NameCount
+	First  *time.Time
+	Latest *time.Time
+}
+
+// How many quotes someone has
+type NameCount struct {
+	Name  string
+	Count int
+}
+
+// Counts the group's quotes overall and by person
+func (d *MemeDB) GetQuoteStats(groupID string) (stats QuoteStats, err error) {
+	id, err := parseGroupID(groupID)
+	if err != nil {
+		return stats, err
+	}
+	err = d.withRetry("GetQuoteStats", func(ctx context.Context) error {
+		stats = QuoteStats{}
+		var first, latest pq.NullTime
+		err := d.db.QueryRowContext(ctx, "SELECT COUNT(*), COUNT(DISTINCT lower(name)), MIN(date), MAX(date) "+
+			"FROM quotes WHERE group_id=$1 AND status='approved'", id).Scan(&stats.Quotes, &stats.People, &first, &latest)
+		if err != nil {
+			return err
+		}
+		if first.Valid {
+			stats.First = &first.Time
+		}
+		if latest.Valid {
+			stats.Latest = &latest.Time
+		}
+		rows, err := d.db.QueryContext(ctx, "SELECT lower(name), COUNT(*) FROM quotes "+
+			"WHERE group_id=$1 AND status='approved' GROUP BY lower(name) ORDER BY COUNT(*) DESC, lower(name)", id)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var count NameCount
+			if err := rows.Scan(&count.Name, &count.Count); err != nil {
+				return err
+			}
+			stats.ByName = append(stats.ByName, count)
+		}
+		return rows.Err()
+	})
+	return stats, err
+}
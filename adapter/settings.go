@@ -0,0 +1,34 @@
+package adapter
+
+// Gets a setting a group changed from chat. Returns ErrNotFound if the
+// group hasn't set it
+func (d *MemeDB) GetGroupSetting(groupID string, setting string) (value string, err error) {
+	id, err := parseGroupID(groupID)
+	if err != nil {
+		return "", err
+	}
+	err = d.queryRow("GetGroupSetting", "SELECT value FROM group_settings WHERE group_id=$1 AND setting=$2",
+		[]interface{}{id, setting}, &value)
+	return value, err
+}
+
+// Saves a setting for the given group
+func (d *MemeDB) SetGroupSetting(groupID string, setting string, value string) error {
+	id, err := parseGroupID(groupID)
+	if err != nil {
+		return err
+	}
+	_, err = d.execIdempotent("SetGroupSetting", "INSERT INTO group_settings (group_id, setting, value) VALUES ($1, $2, $3) "+
+		"ON CONFLICT (group_id, setting) DO UPDATE SET value=EXCLUDED.value", id, setting, value)
+	return err
+}
+
+// Removes a group's setting so the configured default is used again
+func (d *MemeDB) DeleteGroupSetting(groupID string, setting string) error {
+	id, err := parseGroupID(groupID)
+	if err != nil {
+		return err
+	}
+	_, err = d.execIdempotent("DeleteGroupSetting", "DELETE FROM group_settings WHERE group_id=$1 AND setting=$2", id, setting)
+	return err
+}
@@ -0,0 +1,68 @@
+package adapter
+
+import (
+	"context"
+	"database/sql"
+)
+
+// A player's total on a group's trivia scoreboard
+type TriviaScore struct {
+	UserID string
+	Name   string
+	Points int
+}
+
+// Gets a random quote from the group for "who said it?". Only quotes from
+// people with at least two quotes are picked so the answer isn't a giveaway.
+func (d *MemeDB) GetTriviaQuote(groupID string) (Quote, error) {
+	id, err := parseGroupID(groupID)
+	if err != nil {
+		return Quote{}, err
+	}
+	var quote Quote
+	err = d.withRetry("GetTriviaQuote", func(ctx context.Context) error {
+		var scanErr error
+		quote, scanErr = scanQuote(d.db.QueryRowContext(ctx, "SELECT "+quoteColumns+" FROM quotes "+
+			"WHERE group_id=$1 AND status='approved' AND lower(name) IN (SELECT lower(name) FROM quotes "+
+			"WHERE group_id=$1 AND status='approved' GROUP BY lower(name) HAVING COUNT(*) >= 2) "+
+			"ORDER BY random() LIMIT 1", id))
+		return scanErr
+	})
+	return quote, err
+}
+
+// Adds points to a player's trivia score
+func (d *MemeDB) AddTriviaPoints(groupID string, userID string, name string, points int) error {
+	id, err := parseGroupID(groupID)
+	if err != nil {
+		return err
+	}
+	_, err = d.exec("AddTriviaPoints", "INSERT INTO trivia_scores (group_id, user_id, name, points) VALUES ($1, $2, $3, $4) "+
+		"ON CONFLICT (group_id, user_id) DO UPDATE SET name=EXCLUDED.name, points=trivia_scores.points+EXCLUDED.points",
+		id, userID, name, points)
+	return err
+}
+
+// Gets the group's trivia scoreboard, highest first
+func (d *MemeDB) GetTriviaScores(groupID string, limit int) (scores []TriviaScore, err error) {
+	id, err := parseGroupID(groupID)
+	if err != nil {
+		return nil, err
+	}
+	err = d.query("GetTriviaScores", func(rows *sql.Rows) error {
+		scores = nil
+		for rows.Next() {
+			var s TriviaScore
+			if err := rows.Scan(&s.UserID, &s.Name, &s.Points); err != nil {
+				return err
+			}
+			scores = append(scores, s)
+		}
+		return nil
+	}, "SELECT user_id, name, points FROM trivia_scores "+
+		"WHERE group_id=$1 ORDER BY points DESC LIMIT $2", id, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scores, nil
+}
@@ -0,0 +1,106 @@
+package adapter
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Votes plus likes on the bot's posts of a quote, for a row of quotes
+const quoteScore = "((SELECT COALESCE(SUM(vote), 0) FROM quote_votes WHERE quote_id=quotes.id) + " +
+	"(SELECT COALESCE(SUM(likes), 0) FROM quote_messages WHERE quote_id=quotes.id))"
+
+// How likely a quote is to be picked by WeightedRandomSort. Every quote
+// keeps some chance, even when it's been voted down
+const quoteWeight = "GREATEST(1 + " + quoteScore + ", 0.2)"
+
+// A quote along with its score
+type ScoredQuote struct {
+	Quote
+	Score int
+}
+
+// Sets a member's vote on a quote to +1 or -1, replacing any vote they
+// already made, and returns the quote's new score. Returns ErrNotFound if
+// the group has no such quote
+func (d *MemeDB) VoteQuote(groupID string, id uint64, userID string, vote int) (score int, err error) {
+	group, err := parseGroupID(groupID)
+	if err != nil {
+		return 0, err
+	}
+	err = d.transaction("VoteQuote", func(ctx context.Context, tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, "INSERT INTO quote_votes (quote_id, user_id, vote) "+
+			"SELECT id, $3, $4 FROM quotes WHERE id=$1 AND group_id=$2 AND status='approved' "+
+			"ON CONFLICT (quote_id, user_id) DO UPDATE SET vote=EXCLUDED.vote", id, group, userID, vote)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return sql.ErrNoRows
+		}
+		return tx.QueryRowContext(ctx, "SELECT "+quoteScore+" FROM quotes WHERE id=$1", id).Scan(&score)
+	})
+	return score, err
+}
+
+// Remembers that the bot posted a quote as the given message
+func (d *MemeDB) RecordQuoteMessage(groupID string, messageID string, quoteID uint64) error {
+	group, err := parseGroupID(groupID)
+	if err != nil {
+		return err
+	}
+	_, err = d.execIdempotent("RecordQuoteMessage", "INSERT INTO quote_messages (message_id, quote_id, group_id) "+
+		"VALUES ($1, $2, $3) ON CONFLICT (message_id) DO NOTHING", messageID, quoteID, group)
+	return err
+}
+
+// Updates the like count of a message. Messages that aren't posts of
+// quotes are ignored
+func (d *MemeDB) SetMessageLikes(groupID string, messageID string, likes int) error {
+	group, err := parseGroupID(groupID)
+	if err != nil {
+		return err
+	}
+	_, err = d.execIdempotent("SetMessageLikes", "UPDATE quote_messages SET likes=$3 WHERE message_id=$1 AND group_id=$2",
+		messageID, group, likes)
+	return err
+}
+
+// Gets the group's highest scoring quotes
+func (d *MemeDB) GetTopQuotes(groupID string, limit int) (quotes []ScoredQuote, err error) {
+	group, err := parseGroupID(groupID)
+	if err != nil {
+		return nil, err
+	}
+	err = d.query("GetTopQuotes", func(rows *sql.Rows) error {
+		quotes = nil
+		for rows.Next() {
+			var score int
+			quote, err := scanQuote(scoreScanner{rows, &score})
+			if err != nil {
+				return err
+			}
+			quotes = append(quotes, ScoredQuote{Quote: quote, Score: score})
+		}
+		return nil
+	}, "SELECT "+quoteColumns+", "+quoteScore+" AS score FROM quotes "+
+		"WHERE group_id=$1 AND status='approved' ORDER BY score DESC, id LIMIT $2", group, limit)
+	if err != nil {
+		return nil, err
+	}
+	if len(quotes) == 0 {
+		return nil, ErrNotFound
+	}
+	return quotes, nil
+}
+
+// Lets scanQuote read rows that have a score after the quote columns
+type scoreScanner struct {
+	rows  *sql.Rows
+	score *int
+}
+
+func (s scoreScanner) Scan(dest ...interface{}) error {
+	return s.rows.Scan(append(dest, s.score)...)
+}
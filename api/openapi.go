@@ -0,0 +1,182 @@
+package api
+
+// OpenAPI description of the API, served at openapi.json
+const openAPISpec = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "Quotes API",
+    "version": "1.0.0",
+    "description": "Quotes recorded by the GroupMe bots. Every request but this description needs one of the group's API keys as a bearer token."
+  },
+  "servers": [{"url": "/api/v1"}],
+  "security": [{"apiKey": []}],
+  "paths": {
+    "/groups/{groupId}/quotes": {
+      "parameters": [{"$ref": "#/components/parameters/groupId"}],
+      "get": {
+        "summary": "List quotes, newest first",
+        "parameters": [
+          {"name": "name", "in": "query", "description": "Name the quotes are filed under, ignoring case", "schema": {"type": "string"}},
+          {"name": "q", "in": "query", "description": "Text the quote contains, ignoring case", "schema": {"type": "string"}},
+          {"name": "tag", "in": "query", "schema": {"type": "string"}},
+          {"name": "from", "in": "query", "description": "Quotes said at or after this time", "schema": {"type": "string", "format": "date-time"}},
+          {"name": "to", "in": "query", "description": "Quotes said before this time", "schema": {"type": "string", "format": "date-time"}},
+          {"name": "offset", "in": "query", "schema": {"type": "integer", "minimum": 0, "default": 0}},
+          {"name": "limit", "in": "query", "schema": {"type": "integer", "minimum": 1, "maximum": 200, "default": 50}}
+        ],
+        "responses": {
+          "200": {
+            "description": "A page of quotes",
+            "content": {"application/json": {"schema": {
+              "type": "object",
+              "properties": {
+                "quotes": {"type": "array", "items": {"$ref": "#/components/schemas/Quote"}},
+                "total": {"type": "integer", "description": "Quotes matching the filters across all pages"},
+                "offset": {"type": "integer"},
+                "limit": {"type": "integer"}
+              }
+            }}}
+          },
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "503": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "post": {
+        "summary": "Record a quote",
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {
+            "type": "object",
+            "required": ["name", "quote"],
+            "properties": {
+              "name": {"type": "string"},
+              "quote": {"type": "string"},
+              "date": {"type": "string", "format": "date-time", "description": "When it was said. Defaults to now"},
+              "submitter_id": {"type": "string", "description": "Defaults to \"api\""},
+              "submitter_name": {"type": "string", "description": "Defaults to \"api\""}
+            }
+          }}}
+        },
+        "responses": {
+          "201": {
+            "description": "The quote was saved",
+            "headers": {"Location": {"description": "Path of the new quote", "schema": {"type": "string"}}},
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Status"}}}
+          },
+          "202": {"description": "The database is down, so the quote was queued and will be saved when it's back", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Status"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "413": {"$ref": "#/components/responses/Error"},
+          "422": {"description": "The person opted out of quotes or the content filter blocked it", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}}
+        }
+      }
+    },
+    "/groups/{groupId}/quotes/{id}": {
+      "parameters": [
+        {"$ref": "#/components/parameters/groupId"},
+        {"name": "id", "in": "path", "required": true, "schema": {"type": "integer"}}
+      ],
+      "get": {
+        "summary": "Get a quote",
+        "responses": {
+          "200": {"description": "The quote", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Quote"}}}},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "patch": {
+        "summary": "Change a quote. Fields left out keep their values",
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {
+            "type": "object",
+            "properties": {
+              "name": {"type": "string"},
+              "quote": {"type": "string"},
+              "tags": {"type": "array", "items": {"type": "string"}}
+            }
+          }}}
+        },
+        "responses": {
+          "200": {"description": "The changed quote", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Quote"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "413": {"$ref": "#/components/responses/Error"},
+          "422": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "delete": {
+        "summary": "Delete a quote",
+        "responses": {
+          "204": {"description": "Deleted"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/groups/{groupId}/stats": {
+      "parameters": [{"$ref": "#/components/parameters/groupId"}],
+      "get": {
+        "summary": "Count the group's quotes",
+        "responses": {
+          "200": {
+            "description": "Quote counts",
+            "content": {"application/json": {"schema": {
+              "type": "object",
+              "properties": {
+                "quotes": {"type": "integer"},
+                "people": {"type": "integer"},
+                "by_name": {"type": "array", "description": "Most quoted first", "items": {
+                  "type": "object",
+                  "properties": {"name": {"type": "string"}, "count": {"type": "integer"}}
+                }},
+                "first": {"type": "string", "format": "date-time"},
+                "latest": {"type": "string", "format": "date-time"}
+              }
+            }}}
+          },
+          "401": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    }
+  },
+  "components": {
+    "securitySchemes": {
+      "apiKey": {"type": "http", "scheme": "bearer"}
+    },
+    "parameters": {
+      "groupId": {"name": "groupId", "in": "path", "required": true, "description": "GroupMe group ID", "schema": {"type": "string"}}
+    },
+    "schemas": {
+      "Quote": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "integer"},
+          "name": {"type": "string"},
+          "quote": {"type": "string"},
+          "date": {"type": "string", "format": "date-time"},
+          "submitter_id": {"type": "string"},
+          "submitter_name": {"type": "string"},
+          "tags": {"type": "array", "items": {"type": "string"}}
+        }
+      },
+      "Status": {
+        "type": "object",
+        "properties": {
+          "status": {"type": "string", "enum": ["created", "queued"]},
+          "id": {"type": "integer", "description": "ID of the new quote. Left out when it was queued"}
+        }
+      },
+      "Error": {
+        "type": "object",
+        "properties": {"error": {"type": "string"}}
+      }
+    },
+    "responses": {
+      "Error": {"description": "Something went wrong", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}}
+    }
+  }
+}
+`
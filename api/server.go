@@ -0,0 +1,379 @@
+// Package api serves a versioned JSON API over the quote database for
+// other tools. Each group has its own API keys, and a key only reaches
+// the group it belongs to.
+package api
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	srv "github.com/ethanzeigler/groupme/botserver"
+	"github.com/ethanzeigler/groupme/gmbots/adapter"
+	"github.com/sirupsen/logrus"
+)
+
+// Path version 1 of the API is served under
+const Prefix = "/api/v1/"
+
+const (
+	defaultLimit = 50
+	maxLimit     = 200
+)
+
+// Shown as the submitter of quotes created without one
+const defaultSubmitter = "api"
+
+// largest request body accepted, in bytes
+const maxBodySize = 64 << 10
+
+// The MemeDB methods the API uses
+type quoteStore interface {
+	SearchQuotes(groupID string, search adapter.QuoteFilter) ([]adapter.Quote, int, error)
+	GetQuoteByID(groupID string, id uint64) (adapter.Quote, error)
+	CreateQuote(name string, quote string, date time.Time, callback srv.Callback) (uint64, error)
+	UpdateQuote(groupID string, id uint64, name string, quote string, tags []string) (adapter.Quote, error)
+	DeleteQuote(quote adapter.Quote) (sql.Result, error)
+	GetQuoteStats(groupID string) (adapter.QuoteStats, error)
+}
+
+// Serves the API. Every change goes through the same MemeDB methods
+// the chat commands use
+type Server struct {
+	db quoteStore
+	// API keys by group ID
+	keys map[string][]string
+	log  *logrus.Logger
+}
+
+// Creates an API server for the database. keys holds each group's API keys
+func NewServer(db *adapter.MemeDB, keys map[string][]string, log *logrus.Logger) *Server {
+	return &Server{db: db, keys: keys, log: log}
+}
+
+// A quote as the API shows it
+type quoteJSON struct {
+	ID            uint64    `json:"id"`
+	Name          string    `json:"name"`
+	Quote         string    `json:"quote"`
+	Date          time.Time `json:"date"`
+	SubmitterID   string    `json:"submitter_id"`
+	SubmitterName string    `json:"submitter_name,omitempty"`
+	Tags          []string  `json:"tags"`
+}
+
+func toJSON(quote adapter.Quote) quoteJSON {
+	result := quoteJSON{
+		ID:          *quote.ID,
+		Name:        *quote.Name,
+		Quote:       *quote.Quote,
+		Date:        quote.Date.UTC(),
+		SubmitterID: *quote.SubmitterID,
+		Tags:        quote.Tags,
+	}
+	if quote.SubmitterName != nil {
+		result.SubmitterName = *quote.SubmitterName
+	}
+	if result.Tags == nil {
+		result.Tags = []string{}
+	}
+	return result
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, Prefix), "/")
+	if path == "openapi.json" && r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(openAPISpec))
+		return
+	}
+
+	// groups/<group ID>/<resource>...
+	segments := strings.Split(path, "/")
+	if len(segments) < 3 || segments[0] != "groups" {
+		writeError(w, http.StatusNotFound, "Not found")
+		return
+	}
+	groupID := segments[1]
+	if !s.authorized(r, groupID) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="quotes"`)
+		writeError(w, http.StatusUnauthorized, "Missing or wrong API key for this group")
+		return
+	}
+
+	switch {
+	case len(segments) == 3 && segments[2] == "stats" && r.Method == http.MethodGet:
+		s.stats(w, groupID)
+	case len(segments) == 3 && segments[2] == "quotes" && r.Method == http.MethodGet:
+		s.list(w, r, groupID)
+	case len(segments) == 3 && segments[2] == "quotes" && r.Method == http.MethodPost:
+		s.create(w, r, groupID)
+	case len(segments) == 4 && segments[2] == "quotes":
+		id, err := strconv.ParseUint(segments[3], 10, 64)
+		if err != nil {
+			writeError(w, http.StatusNotFound, "Not found")
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			s.get(w, groupID, id)
+		case http.MethodPatch:
+			s.update(w, r, groupID, id)
+		case http.MethodDelete:
+			s.delete(w, groupID, id)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+	default:
+		writeError(w, http.StatusNotFound, "Not found")
+	}
+}
+
+// Checks the request's bearer token against the group's keys
+func (s *Server) authorized(r *http.Request, groupID string) bool {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return false
+	}
+	key := []byte(strings.TrimSpace(strings.TrimPrefix(header, "Bearer ")))
+	authorized := false
+	for _, groupKey := range s.keys[groupID] {
+		// compare against every key so timing doesn't say which one was close
+		if subtle.ConstantTimeCompare(key, []byte(groupKey)) == 1 {
+			authorized = true
+		}
+	}
+	return authorized
+}
+
+// Lists a page of quotes matching the query string filters
+func (s *Server) list(w http.ResponseWriter, r *http.Request, groupID string) {
+	query := r.URL.Query()
+	search := adapter.QuoteFilter{
+		Name:  query.Get("name"),
+		Text:  query.Get("q"),
+		Tag:   query.Get("tag"),
+		Limit: defaultLimit,
+	}
+	var err error
+	if value := query.Get("offset"); value != "" {
+		if search.Offset, err = strconv.Atoi(value); err != nil || search.Offset < 0 {
+			writeError(w, http.StatusBadRequest, "offset must be a number of quotes to skip")
+			return
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		if search.Limit, err = strconv.Atoi(value); err != nil || search.Limit < 1 || search.Limit > maxLimit {
+			writeError(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxLimit))
+			return
+		}
+	}
+	if value := query.Get("from"); value != "" {
+		if search.From, err = time.Parse(time.RFC3339, value); err != nil {
+			writeError(w, http.StatusBadRequest, "from must be an RFC 3339 time")
+			return
+		}
+	}
+	if value := query.Get("to"); value != "" {
+		if search.To, err = time.Parse(time.RFC3339, value); err != nil {
+			writeError(w, http.StatusBadRequest, "to must be an RFC 3339 time")
+			return
+		}
+	}
+
+	quotes, total, err := s.db.SearchQuotes(groupID, search)
+	if err != nil {
+		s.fail(w, err, "Cannot search quotes")
+		return
+	}
+	page := struct {
+		Quotes []quoteJSON `json:"quotes"`
+		Total  int         `json:"total"`
+		Offset int         `json:"offset"`
+		Limit  int         `json:"limit"`
+	}{Quotes: make([]quoteJSON, 0, len(quotes)), Total: total, Offset: search.Offset, Limit: search.Limit}
+	for _, quote := range quotes {
+		page.Quotes = append(page.Quotes, toJSON(quote))
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+func (s *Server) get(w http.ResponseWriter, groupID string, id uint64) {
+	quote, err := s.db.GetQuoteByID(groupID, id)
+	if err != nil {
+		s.fail(w, err, "Cannot get quote")
+		return
+	}
+	writeJSON(w, http.StatusOK, toJSON(quote))
+}
+
+// Records a new quote, pointing to it with Location. Queued quotes
+// don't have an ID until they're saved, so only their status is given
+func (s *Server) create(w http.ResponseWriter, r *http.Request, groupID string) {
+	var body struct {
+		Name          string     `json:"name"`
+		Quote         string     `json:"quote"`
+		Date          *time.Time `json:"date"`
+		SubmitterID   string     `json:"submitter_id"`
+		SubmitterName string     `json:"submitter_name"`
+	}
+	if !decodeBody(w, r, &body) {
+		return
+	}
+	body.Name = strings.TrimSpace(body.Name)
+	body.Quote = strings.TrimSpace(body.Quote)
+	if body.Name == "" || body.Quote == "" {
+		writeError(w, http.StatusBadRequest, "name and quote are required")
+		return
+	}
+	date := time.Now()
+	if body.Date != nil {
+		if body.Date.After(date) {
+			writeError(w, http.StatusBadRequest, "date can't be in the future")
+			return
+		}
+		date = *body.Date
+	}
+	if body.SubmitterID == "" {
+		body.SubmitterID = defaultSubmitter
+	}
+	if body.SubmitterName == "" {
+		body.SubmitterName = defaultSubmitter
+	}
+
+	// the adapter records quotes from chat messages, so make one up
+	callback := srv.Callback{GroupID: groupID, SenderID: body.SubmitterID, Name: body.SubmitterName}
+	id, err := s.db.CreateQuote(body.Name, body.Quote, date, callback)
+	if errors.Is(err, adapter.ErrQueued) {
+		writeJSON(w, http.StatusAccepted, map[string]string{"status": "queued"})
+		return
+	} else if err != nil {
+		s.fail(w, err, "Cannot record quote")
+		return
+	}
+	w.Header().Set("Location", Prefix+"groups/"+groupID+"/quotes/"+strconv.FormatUint(id, 10))
+	writeJSON(w, http.StatusCreated, map[string]interface{}{"status": "created", "id": id})
+}
+
+// Decodes a JSON body of at most maxBodySize bytes into v, writing the
+// error response and returning false if it can't
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).Decode(v)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, "Body can be at most "+strconv.Itoa(maxBodySize)+" bytes")
+		return false
+	} else if err != nil {
+		writeError(w, http.StatusBadRequest, "Body must be a JSON quote")
+		return false
+	}
+	return true
+}
+
+// Changes the fields given in the body, leaving the rest as they are
+func (s *Server) update(w http.ResponseWriter, r *http.Request, groupID string, id uint64) {
+	var body struct {
+		Name  *string   `json:"name"`
+		Quote *string   `json:"quote"`
+		Tags  *[]string `json:"tags"`
+	}
+	if !decodeBody(w, r, &body) {
+		return
+	}
+	current, err := s.db.GetQuoteByID(groupID, id)
+	if err != nil {
+		s.fail(w, err, "Cannot get quote")
+		return
+	}
+	name, text, tags := *current.Name, *current.Quote, current.Tags
+	if body.Name != nil {
+		name = strings.TrimSpace(*body.Name)
+	}
+	if body.Quote != nil {
+		text = strings.TrimSpace(*body.Quote)
+	}
+	if body.Tags != nil {
+		tags = *body.Tags
+	}
+	if name == "" || text == "" {
+		writeError(w, http.StatusBadRequest, "name and quote can't be empty")
+		return
+	}
+
+	updated, err := s.db.UpdateQuote(groupID, id, name, text, tags)
+	if err != nil {
+		s.fail(w, err, "Cannot update quote")
+		return
+	}
+	writeJSON(w, http.StatusOK, toJSON(updated))
+}
+
+func (s *Server) delete(w http.ResponseWriter, groupID string, id uint64) {
+	quote, err := s.db.GetQuoteByID(groupID, id)
+	if err != nil {
+		s.fail(w, err, "Cannot get quote")
+		return
+	}
+	if _, err := s.db.DeleteQuote(quote); err != nil {
+		s.fail(w, err, "Cannot delete quote")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) stats(w http.ResponseWriter, groupID string) {
+	stats, err := s.db.GetQuoteStats(groupID)
+	if err != nil {
+		s.fail(w, err, "Cannot get quote stats")
+		return
+	}
+	type nameJSON struct {
+		Name  string `json:"name"`
+		Count int    `json:"count"`
+	}
+	body := struct {
+		Quotes int        `json:"quotes"`
+		People int        `json:"people"`
+		ByName []nameJSON `json:"by_name"`
+		First  *time.Time `json:"first,omitempty"`
+		Latest *time.Time `json:"latest,omitempty"`
+	}{Quotes: stats.Quotes, People: stats.People, ByName: make([]nameJSON, 0, len(stats.ByName)),
+		First: stats.First, Latest: stats.Latest}
+	for _, count := range stats.ByName {
+		body.ByName = append(body.ByName, nameJSON{Name: count.Name, Count: count.Count})
+	}
+	writeJSON(w, http.StatusOK, body)
+}
+
+// Responds with the status that fits an adapter error, logging the unexpected ones
+func (s *Server) fail(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, adapter.ErrNotFound):
+		writeError(w, http.StatusNotFound, "No such quote")
+	case errors.Is(err, adapter.ErrOptedOut):
+		writeError(w, http.StatusUnprocessableEntity, "They've asked not to be quoted")
+	case errors.Is(err, adapter.ErrFiltered):
+		writeError(w, http.StatusUnprocessableEntity, "The quote didn't get past the content filter")
+	case errors.Is(err, adapter.ErrUnavailable):
+		s.log.WithField("err", err.Error()).Warning(message)
+		writeError(w, http.StatusServiceUnavailable, "The quote database is down right now")
+	default:
+		s.log.WithField("err", err.Error()).Error(message)
+		writeError(w, http.StatusInternalServerError, "Something went wrong")
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
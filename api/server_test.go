@@ -0,0 +1,285 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	srv "github.com/ethanzeigler/groupme/botserver"
+	"github.com/ethanzeigler/groupme/gmbots/adapter"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	testGroup = "1234"
+	testKey   = "secret"
+)
+
+// Keeps quotes in memory instead of the database
+type fakeStore struct {
+	quotes map[uint64]adapter.Quote
+	// returned by every method when set
+	err error
+	// last quote written and search run
+	written srv.Callback
+	search  adapter.QuoteFilter
+}
+
+func newQuote(id uint64, name string, text string) adapter.Quote {
+	date := time.Date(2019, time.May, 1, 17, 30, 0, 0, time.UTC)
+	group := uint64(1234)
+	submitter := "42"
+	return adapter.Quote{ID: &id, Name: &name, Quote: &text, Date: &date, GroupID: &group, SubmitterID: &submitter}
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{quotes: map[uint64]adapter.Quote{
+		1: newQuote(1, "ethan", "I'll fix it tomorrow"),
+		2: newQuote(2, "bob", "Ship it"),
+	}}
+}
+
+func (f *fakeStore) SearchQuotes(groupID string, search adapter.QuoteFilter) ([]adapter.Quote, int, error) {
+	f.search = search
+	if f.err != nil {
+		return nil, 0, f.err
+	}
+	var quotes []adapter.Quote
+	for id := uint64(1); id <= uint64(len(f.quotes)); id++ {
+		if quote, ok := f.quotes[id]; ok && (search.Name == "" || *quote.Name == search.Name) {
+			quotes = append(quotes, quote)
+		}
+	}
+	return quotes, len(quotes), nil
+}
+
+func (f *fakeStore) GetQuoteByID(groupID string, id uint64) (adapter.Quote, error) {
+	if f.err != nil {
+		return adapter.Quote{}, f.err
+	}
+	quote, ok := f.quotes[id]
+	if !ok {
+		return adapter.Quote{}, adapter.ErrNotFound
+	}
+	return quote, nil
+}
+
+func (f *fakeStore) CreateQuote(name string, quote string, date time.Time, callback srv.Callback) (uint64, error) {
+	f.written = callback
+	if f.err != nil {
+		return 0, f.err
+	}
+	id := uint64(len(f.quotes) + 1)
+	f.quotes[id] = newQuote(id, name, quote)
+	return id, nil
+}
+
+func (f *fakeStore) UpdateQuote(groupID string, id uint64, name string, quote string, tags []string) (adapter.Quote, error) {
+	if f.err != nil {
+		return adapter.Quote{}, f.err
+	}
+	updated := newQuote(id, name, quote)
+	updated.Tags = tags
+	f.quotes[id] = updated
+	return updated, nil
+}
+
+func (f *fakeStore) DeleteQuote(quote adapter.Quote) (sql.Result, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	delete(f.quotes, *quote.ID)
+	return nil, nil
+}
+
+func (f *fakeStore) GetQuoteStats(groupID string) (adapter.QuoteStats, error) {
+	if f.err != nil {
+		return adapter.QuoteStats{}, f.err
+	}
+	return adapter.QuoteStats{Quotes: len(f.quotes), People: len(f.quotes),
+		ByName: []adapter.NameCount{{Name: "ethan", Count: 1}, {Name: "bob", Count: 1}}}, nil
+}
+
+func newTestServer(store *fakeStore) *httptest.Server {
+	log := logrus.New()
+	log.Out = io.Discard
+	return httptest.NewServer(&Server{db: store, keys: map[string][]string{testGroup: {"old", testKey}}, log: log})
+}
+
+// Sends a request with the test key unless key is given
+func do(t *testing.T, server *httptest.Server, method string, path string, body string, key ...string) (*http.Response, map[string]interface{}) {
+	t.Helper()
+	req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := testKey
+	if len(key) > 0 {
+		auth = key[0]
+	}
+	if auth != "" {
+		req.Header.Set("Authorization", "Bearer "+auth)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var decoded map[string]interface{}
+	data, _ := io.ReadAll(resp.Body)
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatalf("response isn't a JSON object: %s", data)
+		}
+	}
+	return resp, decoded
+}
+
+func TestAuthorization(t *testing.T) {
+	server := newTestServer(newFakeStore())
+	defer server.Close()
+	tests := []struct {
+		name   string
+		path   string
+		key    string
+		status int
+	}{
+		{"right key", "/api/v1/groups/1234/stats", testKey, http.StatusOK},
+		{"other key of the group", "/api/v1/groups/1234/stats", "old", http.StatusOK},
+		{"no key", "/api/v1/groups/1234/stats", "", http.StatusUnauthorized},
+		{"wrong key", "/api/v1/groups/1234/stats", "guess", http.StatusUnauthorized},
+		{"key of another group", "/api/v1/groups/5678/stats", testKey, http.StatusUnauthorized},
+		{"spec needs no key", "/api/v1/openapi.json", "", http.StatusOK},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, _ := do(t, server, http.MethodGet, tc.path, "", tc.key)
+			if resp.StatusCode != tc.status {
+				t.Errorf("status is %d, want %d", resp.StatusCode, tc.status)
+			}
+			if tc.status == http.StatusUnauthorized && resp.Header.Get("WWW-Authenticate") == "" {
+				t.Error("no WWW-Authenticate header")
+			}
+		})
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		// error every store call returns
+		err    error
+		status int
+		// a field the response should have
+		field string
+	}{
+		{"list", http.MethodGet, "/api/v1/groups/1234/quotes", "", nil, http.StatusOK, "quotes"},
+		{"list bad limit", http.MethodGet, "/api/v1/groups/1234/quotes?limit=0", "", nil, http.StatusBadRequest, "error"},
+		{"list limit too big", http.MethodGet, "/api/v1/groups/1234/quotes?limit=1000", "", nil, http.StatusBadRequest, "error"},
+		{"list bad offset", http.MethodGet, "/api/v1/groups/1234/quotes?offset=-1", "", nil, http.StatusBadRequest, "error"},
+		{"list bad date", http.MethodGet, "/api/v1/groups/1234/quotes?from=yesterday", "", nil, http.StatusBadRequest, "error"},
+		{"list database down", http.MethodGet, "/api/v1/groups/1234/quotes", "", adapter.ErrUnavailable, http.StatusServiceUnavailable, "error"},
+		{"get", http.MethodGet, "/api/v1/groups/1234/quotes/1", "", nil, http.StatusOK, "quote"},
+		{"get missing", http.MethodGet, "/api/v1/groups/1234/quotes/99", "", nil, http.StatusNotFound, "error"},
+		{"get bad id", http.MethodGet, "/api/v1/groups/1234/quotes/abc", "", nil, http.StatusNotFound, "error"},
+		{"create", http.MethodPost, "/api/v1/groups/1234/quotes", `{"name":"ethan","quote":"hi"}`, nil, http.StatusCreated, "status"},
+		{"create queued", http.MethodPost, "/api/v1/groups/1234/quotes", `{"name":"ethan","quote":"hi"}`, adapter.ErrQueued, http.StatusAccepted, "status"},
+		{"create opted out", http.MethodPost, "/api/v1/groups/1234/quotes", `{"name":"ethan","quote":"hi"}`, adapter.ErrOptedOut, http.StatusUnprocessableEntity, "error"},
+		{"create filtered", http.MethodPost, "/api/v1/groups/1234/quotes", `{"name":"ethan","quote":"hi"}`, adapter.ErrFiltered, http.StatusUnprocessableEntity, "error"},
+		{"create missing quote", http.MethodPost, "/api/v1/groups/1234/quotes", `{"name":"ethan"}`, nil, http.StatusBadRequest, "error"},
+		{"create future", http.MethodPost, "/api/v1/groups/1234/quotes", `{"name":"ethan","quote":"hi","date":"2999-01-01T00:00:00Z"}`, nil, http.StatusBadRequest, "error"},
+		{"create bad json", http.MethodPost, "/api/v1/groups/1234/quotes", `{`, nil, http.StatusBadRequest, "error"},
+		{"create too big", http.MethodPost, "/api/v1/groups/1234/quotes", `{"name":"ethan","quote":"` + strings.Repeat("x", maxBodySize) + `"}`,
+			nil, http.StatusRequestEntityTooLarge, "error"},
+		{"update too big", http.MethodPatch, "/api/v1/groups/1234/quotes/1", `{"quote":"` + strings.Repeat("x", maxBodySize) + `"}`,
+			nil, http.StatusRequestEntityTooLarge, "error"},
+		{"update", http.MethodPatch, "/api/v1/groups/1234/quotes/1", `{"tags":["work"]}`, nil, http.StatusOK, "tags"},
+		{"update empty name", http.MethodPatch, "/api/v1/groups/1234/quotes/1", `{"name":" "}`, nil, http.StatusBadRequest, "error"},
+		{"update missing", http.MethodPatch, "/api/v1/groups/1234/quotes/99", `{"name":"bob"}`, nil, http.StatusNotFound, "error"},
+		{"delete", http.MethodDelete, "/api/v1/groups/1234/quotes/2", "", nil, http.StatusNoContent, ""},
+		{"delete missing", http.MethodDelete, "/api/v1/groups/1234/quotes/99", "", nil, http.StatusNotFound, "error"},
+		{"wrong method", http.MethodPut, "/api/v1/groups/1234/quotes/1", "", nil, http.StatusMethodNotAllowed, "error"},
+		{"stats", http.MethodGet, "/api/v1/groups/1234/stats", "", nil, http.StatusOK, "by_name"},
+		{"unknown resource", http.MethodGet, "/api/v1/groups/1234/people", "", nil, http.StatusNotFound, "error"},
+		{"unexpected error", http.MethodGet, "/api/v1/groups/1234/stats", "", io.ErrUnexpectedEOF, http.StatusInternalServerError, "error"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			store := newFakeStore()
+			store.err = tc.err
+			server := newTestServer(store)
+			defer server.Close()
+			resp, body := do(t, server, tc.method, tc.path, tc.body)
+			if resp.StatusCode != tc.status {
+				t.Errorf("status is %d, want %d (%v)", resp.StatusCode, tc.status, body)
+			}
+			if tc.field != "" {
+				if _, ok := body[tc.field]; !ok {
+					t.Errorf("response %v has no %q", body, tc.field)
+				}
+			}
+		})
+	}
+}
+
+func TestListPassesFilters(t *testing.T) {
+	store := newFakeStore()
+	server := newTestServer(store)
+	defer server.Close()
+	resp, body := do(t, server, http.MethodGet,
+		"/api/v1/groups/1234/quotes?name=bob&q=ship&tag=work&offset=5&limit=10&from=2019-01-01T00:00:00Z", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status is %d, want 200", resp.StatusCode)
+	}
+	want := adapter.QuoteFilter{Name: "bob", Text: "ship", Tag: "work", Offset: 5, Limit: 10,
+		From: time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)}
+	if !store.search.From.Equal(want.From) {
+		t.Errorf("from is %v, want %v", store.search.From, want.From)
+	}
+	store.search.From = want.From
+	if store.search != want {
+		t.Errorf("search is %+v, want %+v", store.search, want)
+	}
+	if body["total"] != float64(1) || body["limit"] != float64(10) {
+		t.Errorf("page is %v, want 1 quote of a page of 10", body)
+	}
+}
+
+func TestCreateDefaultsSubmitter(t *testing.T) {
+	store := newFakeStore()
+	server := newTestServer(store)
+	defer server.Close()
+	do(t, server, http.MethodPost, "/api/v1/groups/1234/quotes", `{"name":"ethan","quote":"hi"}`)
+	if store.written.GroupID != testGroup || store.written.SenderID != defaultSubmitter {
+		t.Errorf("quote was written as %+v, want the api submitter in group %s", store.written, testGroup)
+	}
+}
+
+func TestCreateReturnsLocation(t *testing.T) {
+	store := newFakeStore()
+	server := newTestServer(store)
+	defer server.Close()
+	resp, body := do(t, server, http.MethodPost, "/api/v1/groups/1234/quotes", `{"name":"ethan","quote":"hi"}`)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("status is %d, want 201", resp.StatusCode)
+	}
+	if body["id"] != float64(3) {
+		t.Errorf("id is %v, want 3", body["id"])
+	}
+	location := resp.Header.Get("Location")
+	if location != "/api/v1/groups/1234/quotes/3" {
+		t.Fatalf("Location is %q, want the new quote", location)
+	}
+	resp, body = do(t, server, http.MethodGet, location, "")
+	if resp.StatusCode != http.StatusOK || body["quote"] != "hi" {
+		t.Errorf("getting the new quote gave %d %v", resp.StatusCode, body)
+	}
+}
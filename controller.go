@@ -1,21 +1,161 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/ethanzeigler/groupme/botserver"
+	"github.com/ethanzeigler/groupme/gmbots/adapter"
+	"github.com/ethanzeigler/groupme/gmbots/api"
+	"github.com/ethanzeigler/groupme/gmbots/dashboard"
+	"github.com/ethanzeigler/groupme/gmbots/filter"
 	"github.com/ethanzeigler/groupme/gmbots/meme"
+	"github.com/ethanzeigler/groupme/gmbots/report"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
 	"os"
+	"strings"
+	"time"
 )
 
 type GlobalConfig struct {
+	// postgres connection string. Falls back to $DATABASE_URL
+	DatabaseURL string `json:"database_url"`
+	// most messages the bots may post per minute. 0 for no limit
+	OutboundPerMinute int            `json:"outbound_per_minute"`
+	Database          DatabaseConfig `json:"database"`
+	// address to serve /debug/vars on. Empty to turn it off
+	MonitorAddr string `json:"monitor_addr"`
+	// address to serve Prometheus /metrics on. May be the same as
+	// monitor_addr. Empty to turn it off
+	MetricsAddr string `json:"metrics_addr"`
+	// file quote writes are queued in while the database is down.
+	// Empty to turn the queue off
+	WriteQueuePath string             `json:"write_queue_path"`
+	ImageService   ImageServiceConfig `json:"image_service"`
+	// directory of images /caption draws on. Empty to turn captions off
+	CaptionTemplates string            `json:"caption_templates"`
+	GroupMeAPI       GroupMeAPIConfig  `json:"groupme_api"`
+	ContentFilter    FilterConfig      `json:"content_filter"`
+	Dashboard        DashboardConfig   `json:"dashboard"`
+	API              APIConfig         `json:"api"`
+	ErrorReports     ErrorReportConfig `json:"error_reports"`
+}
+
+// Where unexpected errors are reported. Without any of these they're only
+// logged, with the incident ID users are given. The file and webhook get the
+// text and sender ID of the message that hit the error, which /quotes
+// forget me can't delete from them
+type ErrorReportConfig struct {
+	// seconds repeats of an error are reported once in. Defaults to an hour
+	DedupeWindow int `json:"dedupe_window"`
+	// file reports are appended to as JSON lines
+	File string `json:"file"`
+	// URL reports are POSTed to as JSON
+	Webhook string `json:"webhook"`
+	// user sent a DM about each report. Needs a groupme_api token
+	DeveloperID string `json:"developer_id"`
+}
+
+// JSON API for other tools. Groups hand out access with api_keys
+type APIConfig struct {
+	// address to serve the API on. Empty to turn it off
+	Addr string `json:"addr"`
+}
+
+// Web pages admins browse and edit quotes on. Links to them are sent
+// by DM, so the dashboard needs a groupme_api token too
+type DashboardConfig struct {
+	// address to serve the dashboard on. Empty to turn it off
+	Addr string `json:"addr"`
+	// URL the dashboard is reached at from outside, like "https://bots.example.com"
+	BaseURL string `json:"base_url"`
+}
+
+// Words the content filter looks for. The filter is off if there are none
+type FilterConfig struct {
+	// file with one word per line
+	WordList string   `json:"word_list"`
+	Words    []string `json:"words"`
+}
+
+// How a group handles what the content filter finds
+type GroupFilterConfig struct {
+	// block, mask or warn. Defaults to mask
+	Severity string `json:"severity"`
+	// words the group allows anyway
+	Allow []string `json:"allow"`
+}
+
+// Access to the GroupMe API, used to count likes on quotes the bot posts.
+// Likes aren't counted without a token
+type GroupMeAPIConfig struct {
+	// defaults to GroupMe's API
+	URL   string `json:"url"`
+	Token string `json:"token"`
+	// seconds between like counts. Defaults to 10 minutes
+	LikeSyncInterval int `json:"like_sync_interval"`
+}
+
+// Where rendered images are uploaded. Images are turned off without a token
+type ImageServiceConfig struct {
+	// defaults to GroupMe's image service
+	URL   string `json:"url"`
+	Token string `json:"token"`
+}
+
+// Connection pool and query settings. Zero values use the adapter defaults
+type DatabaseConfig struct {
+	MaxOpenConns int `json:"max_open_conns"`
+	MaxIdleConns int `json:"max_idle_conns"`
+	// seconds a connection is reused for
+	ConnMaxLifetime int `json:"conn_max_lifetime"`
+	// milliseconds a query may take
+	QueryTimeout int `json:"query_timeout_ms"`
+	MaxRetries   int `json:"max_retries"`
+	// milliseconds a call may spend retrying altogether
+	MaxRetryTime int `json:"max_retry_time_ms"`
+	// milliseconds to wait before the first retry
+	RetryBackoff int `json:"retry_backoff_ms"`
+	// people whose quotes are cached. -1 turns the cache off
+	CacheSize int `json:"cache_size"`
+	// seconds cached quotes are kept
+	CacheTTL int `json:"cache_ttl"`
+}
+
+type RateLimitConfig struct {
+	// seconds between uses of a command in the group, by feature name
+	Cooldowns map[string]int `json:"cooldowns"`
+	// most commands one user may run every user_window seconds
+	UserLimit  int `json:"user_limit"`
+	UserWindow int `json:"user_window"`
 }
 
 type GroupConfigEntry struct {
-	GroupID   string `json:"group_id"`
-	BotID     string `json:"bot_id"`
-	BotUserID string `json:"bot_user_id"`
-	IsAlpha   bool   `json:"is_alpha"`
+	GroupID   string   `json:"group_id"`
+	BotID     string   `json:"bot_id"`
+	BotUserID string   `json:"bot_user_id"`
+	IsAlpha   bool     `json:"is_alpha"`
+	Admins    []string `json:"admins"`
+	// other bots allowed to trigger commands, by sender ID
+	AllowedBots []string        `json:"allowed_bots"`
+	RateLimits  RateLimitConfig `json:"rate_limits"`
+	// seconds "who said it?" takes guesses for
+	TriviaWindow int `json:"trivia_window"`
+	// IANA time zone quote dates are shown in, like "America/New_York"
+	TimeZone string `json:"time_zone"`
+	// text/template quotes are shown with, like "“{{.Quote}}” — {{.Name}}"
+	QuoteFormat string `json:"quote_format"`
+	// colors of quote cards: light, dark or groupme
+	CardTheme string `json:"card_theme"`
+	// quotes from anyone but admins need an admin's approval
+	Moderated bool `json:"moderated"`
+	// random quotes favor the better voted ones
+	WeightedRandom bool              `json:"weighted_random"`
+	Filter         GroupFilterConfig `json:"filter"`
+	// keys other tools use the API with. Each only reaches this group
+	APIKeys []string `json:"api_keys"`
 }
 
 type MemeMachineConfig struct {
@@ -23,15 +163,207 @@ type MemeMachineConfig struct {
 }
 
 type Config struct {
-	Global GlobalConfig `json:"global"`
+	Global      GlobalConfig      `json:"global"`
+	MemeMachine MemeMachineConfig `json:"meme_machine"`
+}
+
+func (r RateLimitConfig) toRateLimits() (limits meme.RateLimits) {
+	limits.Cooldowns = make(map[string]time.Duration, len(r.Cooldowns))
+	for feature, seconds := range r.Cooldowns {
+		limits.Cooldowns[feature] = time.Duration(seconds) * time.Second
+	}
+	limits.UserLimit = r.UserLimit
+	limits.UserWindow = time.Duration(r.UserWindow) * time.Second
+	return
+}
+
+// Builds the content filter's word list from the file and the words in the config
+func loadWordList(config FilterConfig) (*filter.WordList, error) {
+	words := config.Words
+	if config.WordList != "" {
+		data, err := ioutil.ReadFile(config.WordList)
+		if err != nil {
+			return nil, err
+		}
+		words = append(words, strings.Split(string(data), "\n")...)
+	}
+	return filter.NewWordList(words), nil
+}
+
+func loadConfig(path string) (config Config, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(data, &config)
+	return
 }
 
 func main() {
-	memeChannel := meme.MakeMemeChannel()
 	srv := botserver.NewInstance()
-	srv.RegisterChannel(&memeChannel)
 	srv.Log.Level = logrus.DebugLevel
-	err := srv.ConfigureFromFile("config.json")
+	config, err := loadConfig("config.json")
+	if err != nil {
+		srv.Log.WithField("err", err.Error()).Fatal("Cannot read config")
+	}
+	if config.Global.DatabaseURL == "" {
+		config.Global.DatabaseURL = os.Getenv("DATABASE_URL")
+	}
+	dbConfig := config.Global.Database
+	db, err := adapter.NewMemeDB(config.Global.DatabaseURL, adapter.DBOptions{
+		MaxOpenConns:    dbConfig.MaxOpenConns,
+		MaxIdleConns:    dbConfig.MaxIdleConns,
+		ConnMaxLifetime: time.Duration(dbConfig.ConnMaxLifetime) * time.Second,
+		QueryTimeout:    time.Duration(dbConfig.QueryTimeout) * time.Millisecond,
+		MaxRetries:      dbConfig.MaxRetries,
+		MaxRetryTime:    time.Duration(dbConfig.MaxRetryTime) * time.Millisecond,
+		RetryBackoff:    time.Duration(dbConfig.RetryBackoff) * time.Millisecond,
+		CacheSize:       dbConfig.CacheSize,
+		CacheTTL:        time.Duration(dbConfig.CacheTTL) * time.Second,
+	})
+	if err != nil {
+		// the health check failed. Nothing works without the database
+		srv.Log.WithField("err", err.Error()).Fatal("Cannot open database")
+	}
+	db.PublishStats("db")
+	if config.Global.WriteQueuePath != "" {
+		if err := db.UseWriteQueue(config.Global.WriteQueuePath, 30*time.Second, srv.Log); err != nil {
+			srv.Log.WithField("err", err.Error()).Fatal("Cannot open write queue")
+		}
+	}
+	// metrics share the monitoring server when they're on the same address
+	if addr := config.Global.MetricsAddr; addr != "" && addr == config.Global.MonitorAddr {
+		http.Handle("/metrics", promhttp.Handler())
+	} else if addr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.Handler())
+		go func() {
+			err := http.ListenAndServe(addr, mux)
+			srv.Log.WithField("err", err.Error()).Error("Metrics server stopped")
+		}()
+	}
+	if config.Global.MonitorAddr != "" {
+		// expvar serves the pool stats on the default mux
+		go func() {
+			err := http.ListenAndServe(config.Global.MonitorAddr, nil)
+			srv.Log.WithField("err", err.Error()).Error("Monitoring server stopped")
+		}()
+	}
+	if err := db.CreateTables(); err != nil {
+		srv.Log.WithField("err", err.Error()).Fatal("Cannot create tables")
+	}
+
+	var groups []meme.Group
+	policies := make(map[string]filter.Policy)
+	zones := make(map[string]*time.Location)
+	apiKeys := make(map[string][]string)
+	for _, entry := range config.MemeMachine.GroupEntries {
+		loc, err := time.LoadLocation(entry.TimeZone)
+		if err != nil {
+			srv.Log.WithFields(logrus.Fields{
+				"err":   err.Error(),
+				"group": entry.GroupID,
+			}).Fatal("Invalid time zone")
+		}
+		zones[entry.GroupID] = loc
+		apiKeys[entry.GroupID] = entry.APIKeys
+		if entry.QuoteFormat != "" {
+			if err := meme.ValidateQuoteFormat(entry.QuoteFormat); err != nil {
+				srv.Log.WithFields(logrus.Fields{
+					"err":   err.Error(),
+					"group": entry.GroupID,
+				}).Fatal("Invalid quote format")
+			}
+		}
+		policy := filter.Policy{Severity: filter.Severity(entry.Filter.Severity), Allow: entry.Filter.Allow}
+		if !policy.Severity.Valid() {
+			srv.Log.WithField("group", entry.GroupID).Fatal("Unknown filter severity " + entry.Filter.Severity)
+		}
+		policies[entry.GroupID] = policy
+		if !meme.ValidCardTheme(entry.CardTheme) {
+			srv.Log.WithField("group", entry.GroupID).Fatal("Unknown card theme " + entry.CardTheme)
+		}
+		groups = append(groups, meme.Group{
+			GroupID:        entry.GroupID,
+			BotID:          entry.BotID,
+			BotUserID:      entry.BotUserID,
+			IsAlpha:        entry.IsAlpha,
+			Admins:         entry.Admins,
+			AllowedBots:    entry.AllowedBots,
+			RateLimits:     entry.RateLimits.toRateLimits(),
+			TriviaWindow:   time.Duration(entry.TriviaWindow) * time.Second,
+			TimeZone:       loc,
+			QuoteFormat:    entry.QuoteFormat,
+			CardTheme:      entry.CardTheme,
+			Moderated:      entry.Moderated,
+			WeightedRandom: entry.WeightedRandom,
+			Filter:         policy,
+		})
+	}
+	if wordList, err := loadWordList(config.Global.ContentFilter); err != nil {
+		srv.Log.WithField("err", err.Error()).Fatal("Cannot load content filter")
+	} else if wordList.Len() > 0 {
+		db.UseContentFilter(wordList, policies)
+		meme.SetContentFilter(wordList)
+	}
+	meme.SetOutboundLimit(config.Global.OutboundPerMinute)
+	if imageConfig := config.Global.ImageService; imageConfig.Token != "" {
+		meme.SetImageUploader(adapter.NewImageService(imageConfig.URL, imageConfig.Token))
+	}
+	if config.Global.CaptionTemplates != "" {
+		if err := meme.SetCaptionTemplates(config.Global.CaptionTemplates); err != nil {
+			srv.Log.WithField("err", err.Error()).Fatal("Cannot read caption templates")
+		}
+	}
+	reportConfig := config.Global.ErrorReports
+	var sinks []report.Sink
+	if reportConfig.File != "" {
+		sinks = append(sinks, report.NewFileSink(reportConfig.File))
+	}
+	if reportConfig.Webhook != "" {
+		sinks = append(sinks, report.NewWebhookSink(reportConfig.Webhook))
+	}
+	if reportConfig.DeveloperID != "" {
+		if apiConfig := config.Global.GroupMeAPI; apiConfig.Token != "" {
+			dm := adapter.NewGroupMeAPI(apiConfig.URL, apiConfig.Token)
+			sinks = append(sinks, report.NewDirectMessageSink(dm, reportConfig.DeveloperID))
+		} else {
+			srv.Log.Warning("Error reports need a groupme_api token to DM the developer")
+		}
+	}
+	meme.SetErrorReporter(report.NewReporter(time.Duration(reportConfig.DedupeWindow)*time.Second, srv.Log, sinks...))
+	memeChannel := meme.MakeMemeChannel(db, groups)
+	if dashConfig := config.Global.Dashboard; dashConfig.Addr != "" {
+		mux := http.NewServeMux()
+		mux.Handle(dashboard.Prefix, dashboard.NewServer(db, zones, srv.Log))
+		go func() {
+			err := http.ListenAndServe(dashConfig.Addr, mux)
+			srv.Log.WithField("err", err.Error()).Error("Dashboard server stopped")
+		}()
+		if apiConfig := config.Global.GroupMeAPI; apiConfig.Token != "" {
+			meme.SetDashboard(dashConfig.BaseURL, adapter.NewGroupMeAPI(apiConfig.URL, apiConfig.Token))
+		} else {
+			srv.Log.Warning("The dashboard needs a groupme_api token to send links")
+		}
+	}
+	if apiConfig := config.Global.API; apiConfig.Addr != "" {
+		mux := http.NewServeMux()
+		mux.Handle(api.Prefix, api.NewServer(db, apiKeys, srv.Log))
+		go func() {
+			err := http.ListenAndServe(apiConfig.Addr, mux)
+			srv.Log.WithField("err", err.Error()).Error("API server stopped")
+		}()
+	}
+	if apiConfig := config.Global.GroupMeAPI; apiConfig.Token != "" {
+		interval := time.Duration(apiConfig.LikeSyncInterval) * time.Second
+		if interval <= 0 {
+			interval = 10 * time.Minute
+		}
+		// counts likes in the groups the channel was made with
+		meme.StartLikeSync(adapter.NewGroupMeAPI(apiConfig.URL, apiConfig.Token), interval, srv.Log)
+	}
+	srv.RegisterChannel(&memeChannel)
+	err = srv.ConfigureFromFile("config.json")
 	if err != nil {
 		// Something is very wrong. Die.
 		os.Exit(1)
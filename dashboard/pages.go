@@ -0,0 +1,84 @@
+package dashboard
+
+import "html/template"
+
+const layout = `{{define "head"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Quotes</title>
+<style>
+body { font-family: sans-serif; margin: 2em auto; max-width: 1000px; padding: 0 1em; color: #212121; }
+a { color: #0082b3; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: .5em; border-bottom: 1px solid #ddd; vertical-align: top; }
+form.filters input, form.filters select { margin-right: .5em; }
+.muted { color: #757575; }
+.error { color: #b00020; }
+textarea { width: 100%; }
+</style>
+</head>
+<body>
+{{end}}
+{{define "foot"}}</body>
+</html>
+{{end}}`
+
+var listPage = template.Must(template.New("list").Parse(layout + `{{template "head"}}
+<h1><a href="{{.Home}}">Quotes</a></h1>
+<p class="muted">{{.Stats.Quotes}} quotes from {{.Stats.People}} people</p>
+<form class="filters" method="get" action="{{.Home}}">
+	<input type="search" name="q" placeholder="Search" value="{{.Query.Get "q"}}">
+	<select name="name">
+		<option value="">Everyone</option>
+		{{$name := .Query.Get "name"}}
+		{{range .Stats.ByName}}<option value="{{.Name}}"{{if eq .Name $name}} selected{{end}}>{{.Name}} ({{.Count}})</option>{{end}}
+	</select>
+	<input type="text" name="tag" placeholder="Tag" value="{{.Query.Get "tag"}}">
+	<input type="date" name="from" value="{{.Query.Get "from"}}">
+	<input type="date" name="to" value="{{.Query.Get "to"}}">
+	<button type="submit">Filter</button>
+</form>
+<p class="muted">{{.Total}} matching</p>
+<table>
+	<tr><th>#</th><th>Name</th><th>Quote</th><th>Date</th><th>Tags</th><th></th></tr>
+	{{range .Quotes}}
+	<tr>
+		<td>{{.ID}}</td>
+		<td>{{.Name}}</td>
+		<td>{{.Quote}}{{if .Submitter}}<br><span class="muted">recorded by {{.Submitter}}</span>{{end}}</td>
+		<td>{{.Date}}</td>
+		<td>{{.Tags}}</td>
+		<td>
+			<a href="{{.EditLink}}">Edit</a>
+			<form method="post" action="{{.DeleteURL}}" onsubmit="return confirm('Delete this quote?')">
+				<button type="submit">Delete</button>
+			</form>
+		</td>
+	</tr>
+	{{else}}
+	<tr><td colspan="6" class="muted">No quotes found</td></tr>
+	{{end}}
+</table>
+<p>{{if .Prev}}<a href="{{.Prev}}">Newer</a>{{end}} {{if .Next}}<a href="{{.Next}}">Older</a>{{end}}</p>
+{{template "foot"}}`))
+
+var editPage = template.Must(template.New("edit").Parse(layout + `{{template "head"}}
+<h1><a href="{{.Home}}">Quotes</a></h1>
+<h2>Edit #{{.Quote.ID}}</h2>
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+<form method="post" action="{{.Quote.EditLink}}">
+	<p><label>Name<br><input type="text" name="name" value="{{.Quote.Name}}" required></label></p>
+	<p><label>Quote<br><textarea name="quote" rows="4" required>{{.Quote.Quote}}</textarea></label></p>
+	<p><label>Tags, separated by commas<br><input type="text" name="tags" value="{{.Quote.Tags}}"></label></p>
+	<p class="muted">Said {{.Quote.Date}}</p>
+	<button type="submit">Save</button>
+	<a href="{{.Home}}">Cancel</a>
+</form>
+{{template "foot"}}`))
+
+var errorPage = template.Must(template.New("error").Parse(layout + `{{template "head"}}
+<h1>Quotes</h1>
+<p>{{.}}</p>
+{{template "foot"}}`))
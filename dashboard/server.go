@@ -0,0 +1,309 @@
+// Package dashboard serves web pages for browsing and managing a
+// group's quotes. Each group's pages are reached through a token link
+// the bot sends to its admins.
+package dashboard
+
+import (
+	"database/sql"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethanzeigler/groupme/gmbots/adapter"
+	"github.com/sirupsen/logrus"
+)
+
+// Path the dashboard is served under
+const Prefix = "/dashboard/"
+
+// quotes shown on a page
+const pageSize = 50
+
+// The MemeDB methods the dashboard uses
+type quoteStore interface {
+	GetDashboardToken(token string) (groupID string, userID string, err error)
+	SearchQuotes(groupID string, search adapter.QuoteFilter) ([]adapter.Quote, int, error)
+	GetQuoteStats(groupID string) (adapter.QuoteStats, error)
+	GetQuoteByID(groupID string, id uint64) (adapter.Quote, error)
+	UpdateQuote(groupID string, id uint64, name string, quote string, tags []string) (adapter.Quote, error)
+	DeleteQuote(quote adapter.Quote) (sql.Result, error)
+	WriteAudit(groupID string, userID string, action string, detail string) error
+}
+
+// Serves the dashboard. Every change goes through the same MemeDB
+// methods the chat commands use
+type Server struct {
+	db quoteStore
+	// zone dates are shown and filtered in, by group ID
+	zones map[string]*time.Location
+	log   *logrus.Logger
+}
+
+// Creates a dashboard server for the database. Groups missing from zones use UTC
+func NewServer(db *adapter.MemeDB, zones map[string]*time.Location, log *logrus.Logger) *Server {
+	return &Server{db: db, zones: zones, log: log}
+}
+
+// A request for a group's pages, after its token checked out
+type request struct {
+	w       http.ResponseWriter
+	r       *http.Request
+	token   string
+	groupID string
+	userID  string
+	zone    *time.Location
+}
+
+// Link to a page of the dashboard
+func (req *request) link(path string) string {
+	return Prefix + url.PathEscape(req.token) + "/" + path
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// /dashboard/<token>/<rest>
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, Prefix), "/", 2)
+	token := parts[0]
+	rest := ""
+	if len(parts) > 1 {
+		rest = strings.Trim(parts[1], "/")
+	}
+
+	groupID, userID, err := s.db.GetDashboardToken(token)
+	if errors.Is(err, adapter.ErrNotFound) {
+		s.render(w, http.StatusNotFound, errorPage, "This link has expired. Ask the bot for a new one with /quotes dashboard")
+		return
+	} else if err != nil {
+		s.fail(w, err, "Cannot check dashboard token")
+		return
+	}
+	req := &request{w: w, r: r, token: token, groupID: groupID, userID: userID, zone: time.UTC}
+	if zone := s.zones[groupID]; zone != nil {
+		req.zone = zone
+	}
+
+	segments := strings.Split(rest, "/")
+	switch {
+	case rest == "" && r.Method == http.MethodGet:
+		s.list(req)
+	case len(segments) == 2 && segments[0] == "quotes":
+		id, err := strconv.ParseUint(segments[1], 10, 64)
+		if err != nil {
+			http.NotFound(w, r)
+		} else if r.Method == http.MethodPost {
+			s.update(req, id)
+		} else {
+			s.edit(req, id)
+		}
+	case len(segments) == 3 && segments[0] == "quotes" && segments[2] == "delete" && r.Method == http.MethodPost:
+		id, err := strconv.ParseUint(segments[1], 10, 64)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		s.delete(req, id)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Row of the quote table
+type quoteRow struct {
+	ID        uint64
+	Name      string
+	Quote     string
+	Date      string
+	Submitter string
+	Tags      string
+	EditLink  string
+	DeleteURL string
+}
+
+func (req *request) row(quote adapter.Quote) quoteRow {
+	row := quoteRow{
+		ID:        *quote.ID,
+		Name:      *quote.Name,
+		Quote:     *quote.Quote,
+		Date:      quote.Date.In(req.zone).Format("Jan 2, 2006 3:04 PM"),
+		Tags:      strings.Join(quote.Tags, ", "),
+		EditLink:  req.link("quotes/" + strconv.FormatUint(*quote.ID, 10)),
+		DeleteURL: req.link("quotes/" + strconv.FormatUint(*quote.ID, 10) + "/delete"),
+	}
+	if quote.SubmitterName != nil {
+		row.Submitter = *quote.SubmitterName
+	}
+	return row
+}
+
+// Lists the group's quotes with the filters from the query string
+func (s *Server) list(req *request) {
+	query := req.r.URL.Query()
+	page, _ := strconv.Atoi(query.Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	search := adapter.QuoteFilter{
+		Name:   strings.TrimSpace(query.Get("name")),
+		Text:   strings.TrimSpace(query.Get("q")),
+		Tag:    strings.TrimSpace(query.Get("tag")),
+		Offset: (page - 1) * pageSize,
+		Limit:  pageSize,
+	}
+	if from, err := time.ParseInLocation("2006-01-02", query.Get("from"), req.zone); err == nil {
+		search.From = from
+	}
+	if to, err := time.ParseInLocation("2006-01-02", query.Get("to"), req.zone); err == nil {
+		// the whole day is included
+		search.To = to.AddDate(0, 0, 1)
+	}
+
+	quotes, total, err := s.db.SearchQuotes(req.groupID, search)
+	if err != nil {
+		s.fail(req.w, err, "Cannot search quotes")
+		return
+	}
+	stats, err := s.db.GetQuoteStats(req.groupID)
+	if err != nil {
+		s.fail(req.w, err, "Cannot get quote stats")
+		return
+	}
+
+	data := struct {
+		Quotes []quoteRow
+		Stats  adapter.QuoteStats
+		Total  int
+		Query  url.Values
+		Prev   string
+		Next   string
+		Home   string
+	}{Stats: stats, Total: total, Query: query, Home: req.link("")}
+	for _, quote := range quotes {
+		data.Quotes = append(data.Quotes, req.row(quote))
+	}
+	pageLink := func(n int) string {
+		q := url.Values{}
+		for key, values := range query {
+			q[key] = values
+		}
+		q.Set("page", strconv.Itoa(n))
+		return req.link("") + "?" + q.Encode()
+	}
+	if page > 1 {
+		data.Prev = pageLink(page - 1)
+	}
+	if page*pageSize < total {
+		data.Next = pageLink(page + 1)
+	}
+	s.render(req.w, http.StatusOK, listPage, data)
+}
+
+type editData struct {
+	Quote quoteRow
+	Error string
+	Home  string
+}
+
+// Shows the form for editing a quote
+func (s *Server) edit(req *request, id uint64) {
+	quote, err := s.db.GetQuoteByID(req.groupID, id)
+	if errors.Is(err, adapter.ErrNotFound) {
+		http.NotFound(req.w, req.r)
+		return
+	} else if err != nil {
+		s.fail(req.w, err, "Cannot get quote")
+		return
+	}
+	s.render(req.w, http.StatusOK, editPage, editData{Quote: req.row(quote), Home: req.link("")})
+}
+
+// Saves the edit form
+func (s *Server) update(req *request, id uint64) {
+	name := strings.TrimSpace(req.r.PostFormValue("name"))
+	text := strings.TrimSpace(req.r.PostFormValue("quote"))
+	tags := strings.Split(req.r.PostFormValue("tags"), ",")
+	if name == "" || text == "" {
+		quote, err := s.db.GetQuoteByID(req.groupID, id)
+		if err != nil {
+			s.fail(req.w, err, "Cannot get quote")
+			return
+		}
+		s.render(req.w, http.StatusBadRequest, editPage, editData{Quote: req.row(quote),
+			Error: "The name and quote can't be empty", Home: req.link("")})
+		return
+	}
+
+	_, err := s.db.UpdateQuote(req.groupID, id, name, text, tags)
+	if errors.Is(err, adapter.ErrNotFound) {
+		http.NotFound(req.w, req.r)
+		return
+	} else if errors.Is(err, adapter.ErrFiltered) || errors.Is(err, adapter.ErrOptedOut) {
+		quote, getErr := s.db.GetQuoteByID(req.groupID, id)
+		if getErr != nil {
+			s.fail(req.w, getErr, "Cannot get quote")
+			return
+		}
+		message := "That quote didn't get past the content filter"
+		if errors.Is(err, adapter.ErrOptedOut) {
+			message = name + " has asked not to be quoted"
+		}
+		s.render(req.w, http.StatusBadRequest, editPage, editData{Quote: req.row(quote),
+			Error: message, Home: req.link("")})
+		return
+	} else if err != nil {
+		s.fail(req.w, err, "Cannot update quote")
+		return
+	}
+	s.audit(req, "dashboard edit", id)
+	http.Redirect(req.w, req.r, req.link(""), http.StatusSeeOther)
+}
+
+// Deletes a quote
+func (s *Server) delete(req *request, id uint64) {
+	quote, err := s.db.GetQuoteByID(req.groupID, id)
+	if errors.Is(err, adapter.ErrNotFound) {
+		http.NotFound(req.w, req.r)
+		return
+	} else if err != nil {
+		s.fail(req.w, err, "Cannot get quote")
+		return
+	}
+	if _, err := s.db.DeleteQuote(quote); err != nil {
+		s.fail(req.w, err, "Cannot delete quote")
+		return
+	}
+	s.audit(req, "dashboard delete", id)
+	http.Redirect(req.w, req.r, req.link(""), http.StatusSeeOther)
+}
+
+// Records who changed what, since the dashboard acts for an admin
+func (s *Server) audit(req *request, action string, id uint64) {
+	if err := s.db.WriteAudit(req.groupID, req.userID, action, "quote "+strconv.FormatUint(id, 10)); err != nil {
+		s.log.WithFields(logrus.Fields{
+			"err":   err.Error(),
+			"group": req.groupID,
+		}).Error("Cannot write audit log")
+	}
+}
+
+func (s *Server) render(w http.ResponseWriter, status int, page *template.Template, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := page.Execute(w, data); err != nil {
+		s.log.WithField("err", err.Error()).Error("Cannot render dashboard page")
+	}
+}
+
+// Logs an error and shows a generic error page
+func (s *Server) fail(w http.ResponseWriter, err error, message string) {
+	s.log.WithField("err", err.Error()).Error(message)
+	status := http.StatusInternalServerError
+	text := "Something went wrong. Try again in a bit"
+	if errors.Is(err, adapter.ErrUnavailable) {
+		status = http.StatusServiceUnavailable
+		text = "The quote database is down right now. Try again in a bit"
+	}
+	s.render(w, status, errorPage, text)
+}
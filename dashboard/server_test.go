@@ -0,0 +1,206 @@
+package dashboard
+
+import (
+	"database/sql"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethanzeigler/groupme/gmbots/adapter"
+	"github.com/sirupsen/logrus"
+)
+
+// A dashboard link the bot handed out
+type fakeToken struct {
+	groupID string
+	userID  string
+	expires time.Time
+}
+
+// Keeps quotes and tokens in memory instead of the database
+type fakeStore struct {
+	tokens map[string]fakeToken
+	quotes map[uint64]adapter.Quote
+	// names that opted out of being quoted
+	optedOut map[string]bool
+	// returned by searches when set
+	err    error
+	audits []string
+}
+
+func newQuote(id uint64, name string, text string) adapter.Quote {
+	date := time.Date(2019, time.May, 1, 17, 30, 0, 0, time.UTC)
+	return adapter.Quote{ID: &id, Name: &name, Quote: &text, Date: &date}
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{
+		tokens: map[string]fakeToken{
+			"good":    {"1234", "42", time.Now().Add(time.Hour)},
+			"expired": {"1234", "42", time.Now().Add(-time.Minute)},
+		},
+		quotes: map[uint64]adapter.Quote{
+			1: newQuote(1, "ethan", "I'll fix it tomorrow"),
+			2: newQuote(2, "bob", "Ship it"),
+		},
+		optedOut: map[string]bool{"mike": true},
+	}
+}
+
+func (f *fakeStore) GetDashboardToken(token string) (string, string, error) {
+	t, ok := f.tokens[token]
+	if !ok || time.Now().After(t.expires) {
+		return "", "", adapter.ErrNotFound
+	}
+	return t.groupID, t.userID, nil
+}
+
+func (f *fakeStore) SearchQuotes(groupID string, search adapter.QuoteFilter) ([]adapter.Quote, int, error) {
+	if f.err != nil {
+		return nil, 0, f.err
+	}
+	var quotes []adapter.Quote
+	for id := uint64(1); id <= 2; id++ {
+		if quote, ok := f.quotes[id]; ok {
+			quotes = append(quotes, quote)
+		}
+	}
+	return quotes, len(quotes), nil
+}
+
+func (f *fakeStore) GetQuoteStats(groupID string) (adapter.QuoteStats, error) {
+	return adapter.QuoteStats{Quotes: len(f.quotes), People: len(f.quotes)}, nil
+}
+
+func (f *fakeStore) GetQuoteByID(groupID string, id uint64) (adapter.Quote, error) {
+	quote, ok := f.quotes[id]
+	if !ok {
+		return adapter.Quote{}, adapter.ErrNotFound
+	}
+	return quote, nil
+}
+
+func (f *fakeStore) UpdateQuote(groupID string, id uint64, name string, text string, tags []string) (adapter.Quote, error) {
+	if _, ok := f.quotes[id]; !ok {
+		return adapter.Quote{}, adapter.ErrNotFound
+	}
+	if f.optedOut[strings.ToLower(name)] {
+		return adapter.Quote{}, adapter.ErrOptedOut
+	}
+	f.quotes[id] = newQuote(id, name, text)
+	return f.quotes[id], nil
+}
+
+func (f *fakeStore) DeleteQuote(quote adapter.Quote) (sql.Result, error) {
+	delete(f.quotes, *quote.ID)
+	return nil, nil
+}
+
+func (f *fakeStore) WriteAudit(groupID string, userID string, action string, detail string) error {
+	f.audits = append(f.audits, action+" "+detail+" by "+userID)
+	return nil
+}
+
+func newTestServer(store *fakeStore) *httptest.Server {
+	log := logrus.New()
+	log.Out = io.Discard
+	return httptest.NewServer(&Server{db: store, log: log})
+}
+
+func TestDashboard(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		// form posted, if any
+		form url.Values
+		err  error
+		// status before redirects are followed
+		status int
+		// text the page should have
+		page string
+		// what the audit log should end up with
+		audit string
+	}{
+		{"list", http.MethodGet, "good/", nil, nil, http.StatusOK, "Ship it", ""},
+		{"expired link", http.MethodGet, "expired/", nil, nil, http.StatusNotFound, "expired", ""},
+		{"unknown link", http.MethodGet, "guess/", nil, nil, http.StatusNotFound, "expired", ""},
+		{"expired link can't edit", http.MethodPost, "expired/quotes/1",
+			url.Values{"name": {"ethan"}, "quote": {"changed"}}, nil, http.StatusNotFound, "expired", ""},
+		{"database down", http.MethodGet, "good/", nil, adapter.ErrUnavailable, http.StatusServiceUnavailable, "down", ""},
+		{"edit form", http.MethodGet, "good/quotes/1", nil, nil, http.StatusOK, "I&#39;ll fix it tomorrow", ""},
+		{"edit missing", http.MethodGet, "good/quotes/9", nil, nil, http.StatusNotFound, "", ""},
+		{"save", http.MethodPost, "good/quotes/1",
+			url.Values{"name": {"ethan"}, "quote": {"Fixed it"}, "tags": {"work"}}, nil, http.StatusSeeOther, "", "dashboard edit quote 1 by 42"},
+		{"save empty", http.MethodPost, "good/quotes/1",
+			url.Values{"name": {" "}, "quote": {"Fixed it"}}, nil, http.StatusBadRequest, "can&#39;t be empty", ""},
+		{"rename to opted out", http.MethodPost, "good/quotes/1",
+			url.Values{"name": {"Mike"}, "quote": {"I'll fix it tomorrow"}}, nil, http.StatusBadRequest, "Mike has asked not to be quoted", ""},
+		{"save missing", http.MethodPost, "good/quotes/9",
+			url.Values{"name": {"ethan"}, "quote": {"hi"}}, nil, http.StatusNotFound, "", ""},
+		{"delete", http.MethodPost, "good/quotes/2/delete", url.Values{}, nil, http.StatusSeeOther, "", "dashboard delete quote 2 by 42"},
+		{"delete missing", http.MethodPost, "good/quotes/9/delete", url.Values{}, nil, http.StatusNotFound, "", ""},
+		{"delete needs a post", http.MethodGet, "good/quotes/2/delete", nil, nil, http.StatusNotFound, "", ""},
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			store := newFakeStore()
+			store.err = tc.err
+			server := newTestServer(store)
+			defer server.Close()
+
+			var body io.Reader
+			if tc.form != nil {
+				body = strings.NewReader(tc.form.Encode())
+			}
+			req, err := http.NewRequest(tc.method, server.URL+Prefix+tc.path, body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.form != nil {
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			page, _ := io.ReadAll(resp.Body)
+
+			if resp.StatusCode != tc.status {
+				t.Errorf("status is %d, want %d", resp.StatusCode, tc.status)
+			}
+			if !strings.Contains(string(page), tc.page) {
+				t.Errorf("page doesn't have %q:\n%s", tc.page, page)
+			}
+			if tc.status == http.StatusSeeOther && resp.Header.Get("Location") != Prefix+"good/" {
+				t.Errorf("redirected to %q, want the list", resp.Header.Get("Location"))
+			}
+			audit := strings.Join(store.audits, "\n")
+			if audit != tc.audit {
+				t.Errorf("audit log is %q, want %q", audit, tc.audit)
+			}
+		})
+	}
+}
+
+func TestDashboardRenameToOptedOutKeepsQuote(t *testing.T) {
+	store := newFakeStore()
+	server := newTestServer(store)
+	defer server.Close()
+	resp, err := http.PostForm(server.URL+Prefix+"good/quotes/1", url.Values{"name": {"mike"}, "quote": {"changed"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if quote := store.quotes[1]; *quote.Name != "ethan" || *quote.Quote != "I'll fix it tomorrow" {
+		t.Errorf("quote became %s: %s", *quote.Name, *quote.Quote)
+	}
+}
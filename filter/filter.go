@@ -0,0 +1,168 @@
+// Package filter finds unwanted words in text and decides what to do
+// about them for a group.
+package filter
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// What happens to text the filter matches
+type Severity string
+
+const (
+	// the text isn't saved or posted
+	Block Severity = "block"
+	// matches are replaced with asterisks
+	Mask Severity = "mask"
+	// the text goes through, but is logged
+	Warn Severity = "warn"
+)
+
+// A place in some text the filter matched
+type Match struct {
+	// the matched word in lower case
+	Term string
+	// byte offsets of the matched text
+	Start int
+	End   int
+}
+
+// Finds unwanted words in text
+type Filter interface {
+	Find(text string) []Match
+}
+
+// How a group handles matches
+type Policy struct {
+	// empty for Mask
+	Severity Severity
+	// words the group is fine with, even if the filter matches them
+	Allow []string
+}
+
+// Returns true for known severities, or an empty one
+func (s Severity) Valid() bool {
+	switch s {
+	case "", Block, Mask, Warn:
+		return true
+	}
+	return false
+}
+
+// Outcome of running text through a filter under a policy
+type Result struct {
+	// the text to use. Masked if the policy masks
+	Text    string
+	Matches []Match
+	// the text shouldn't be used at all
+	Blocked bool
+}
+
+// Runs text through the filter and applies the policy to what it finds
+func Apply(f Filter, p Policy, text string) Result {
+	result := Result{Text: text}
+	if f == nil {
+		return result
+	}
+	for _, match := range f.Find(text) {
+		if !allowed(p.Allow, text[match.Start:match.End]) {
+			result.Matches = append(result.Matches, match)
+		}
+	}
+	if len(result.Matches) == 0 {
+		return result
+	}
+	switch p.Severity {
+	case Block:
+		result.Blocked = true
+	case Warn:
+	default:
+		result.Text = mask(text, result.Matches)
+	}
+	return result
+}
+
+func allowed(allow []string, word string) bool {
+	for _, a := range allow {
+		if strings.EqualFold(a, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// Replaces everything but the first letter of each match with asterisks
+func mask(text string, matches []Match) string {
+	var b strings.Builder
+	last := 0
+	for _, match := range matches {
+		if match.Start < last {
+			continue
+		}
+		b.WriteString(text[last:match.Start])
+		for n, r := range text[match.Start:match.End] {
+			if n == 0 {
+				b.WriteRune(r)
+			} else {
+				b.WriteByte('*')
+			}
+		}
+		last = match.End
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// Matches whole words from a list, ignoring case. A word ending in *
+// matches anything starting with it, and lines starting with # are skipped
+type WordList struct {
+	pattern *regexp.Regexp
+	terms   []string
+}
+
+// Creates a filter for the given words
+func NewWordList(words []string) *WordList {
+	var alternatives []string
+	var terms []string
+	for _, word := range words {
+		word = strings.ToLower(strings.TrimSpace(word))
+		if word == "" || strings.HasPrefix(word, "#") {
+			continue
+		}
+		terms = append(terms, word)
+		if strings.HasSuffix(word, "*") {
+			alternatives = append(alternatives, regexp.QuoteMeta(strings.TrimSuffix(word, "*"))+`\w*`)
+		} else {
+			alternatives = append(alternatives, regexp.QuoteMeta(word))
+		}
+	}
+	w := &WordList{terms: terms}
+	if len(alternatives) > 0 {
+		// longest first so "foobar" wins over "foo"
+		sort.Slice(alternatives, func(a, b int) bool { return len(alternatives[a]) > len(alternatives[b]) })
+		w.pattern = regexp.MustCompile(`(?i)\b(?:` + strings.Join(alternatives, "|") + `)\b`)
+	}
+	return w
+}
+
+// Number of words in the list
+func (w *WordList) Len() int {
+	return len(w.terms)
+}
+
+func (w *WordList) Find(text string) []Match {
+	if w.pattern == nil {
+		return nil
+	}
+	var matches []Match
+	for _, loc := range w.pattern.FindAllStringIndex(text, -1) {
+		matches = append(matches, Match{
+			Term:  strings.ToLower(text[loc[0]:loc[1]]),
+			Start: loc[0],
+			End:   loc[1],
+		})
+	}
+	return matches
+}
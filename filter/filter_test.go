@@ -0,0 +1,107 @@
+package filter
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWordListFind(t *testing.T) {
+	list := NewWordList([]string{"# comment", "darn", "  HECK ", "frick*", "", "dang it"})
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"all good here", nil},
+		{"darn", []string{"darn"}},
+		{"Darn it, heck!", []string{"darn", "heck"}},
+		{"darned", nil},
+		{"undarn", nil},
+		{"frick fricking FRICKIN", []string{"frick", "fricking", "frickin"}},
+		{"dang it all", []string{"dang it"}},
+		{"dang", nil},
+		{"comment", nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.text, func(t *testing.T) {
+			var got []string
+			for _, match := range list.Find(tc.text) {
+				if match.Term != strings.ToLower(tc.text[match.Start:match.End]) {
+					t.Errorf("match %q doesn't cover %q", match.Term, tc.text[match.Start:match.End])
+				}
+				got = append(got, match.Term)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Find(%q) = %v, want %v", tc.text, got, tc.want)
+			}
+		})
+	}
+	if list.Len() != 4 {
+		t.Errorf("Len() = %d, want 4", list.Len())
+	}
+}
+
+func TestWordListPrefersLongest(t *testing.T) {
+	list := NewWordList([]string{"foo", "foobar"})
+	matches := list.Find("foobar")
+	if len(matches) != 1 || matches[0].Term != "foobar" {
+		t.Errorf("Find(foobar) = %+v, want one match of foobar", matches)
+	}
+}
+
+func TestEmptyWordList(t *testing.T) {
+	if matches := NewWordList([]string{"# nothing", " "}).Find("anything"); matches != nil {
+		t.Errorf("empty list found %v", matches)
+	}
+}
+
+func TestApply(t *testing.T) {
+	list := NewWordList([]string{"darn", "heck"})
+	tests := []struct {
+		name    string
+		filter  Filter
+		policy  Policy
+		text    string
+		want    string
+		matches int
+		blocked bool
+	}{
+		{"no filter", nil, Policy{Severity: Block}, "darn", "darn", 0, false},
+		{"clean", list, Policy{}, "hello", "hello", 0, false},
+		{"masks by default", list, Policy{}, "oh darn it", "oh d*** it", 1, false},
+		{"masks two", list, Policy{Severity: Mask}, "Darn, heck", "D***, h***", 2, false},
+		{"blocks", list, Policy{Severity: Block}, "oh heck", "oh heck", 1, true},
+		{"warns", list, Policy{Severity: Warn}, "oh heck", "oh heck", 1, false},
+		{"allowed", list, Policy{Severity: Block, Allow: []string{"HECK"}}, "oh heck", "oh heck", 0, false},
+		{"allowed one of two", list, Policy{Allow: []string{"heck"}}, "heck darn", "heck d***", 1, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			result := Apply(tc.filter, tc.policy, tc.text)
+			if result.Text != tc.want {
+				t.Errorf("text is %q, want %q", result.Text, tc.want)
+			}
+			if len(result.Matches) != tc.matches {
+				t.Errorf("%d matches, want %d", len(result.Matches), tc.matches)
+			}
+			if result.Blocked != tc.blocked {
+				t.Errorf("blocked is %v, want %v", result.Blocked, tc.blocked)
+			}
+		})
+	}
+}
+
+func TestMaskMultibyte(t *testing.T) {
+	list := NewWordList([]string{"née"})
+	if got := Apply(list, Policy{}, "la née!").Text; got != "la n**!" {
+		t.Errorf("masked text is %q, want %q", got, "la n**!")
+	}
+}
+
+func TestSeverityValid(t *testing.T) {
+	for severity, want := range map[Severity]bool{"": true, Block: true, Mask: true, Warn: true, "delete": false, "BLOCK": false} {
+		if got := severity.Valid(); got != want {
+			t.Errorf("Severity(%q).Valid() = %v, want %v", severity, got, want)
+		}
+	}
+}
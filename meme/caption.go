@@ -0,0 +1,214 @@
+package meme
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	// template images may be in any of these
+	_ "image/gif"
+	_ "image/png"
+
+	srv "github.com/ethanzeigler/groupme/botserver"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	// most lines the top or bottom text is wrapped onto
+	maxCaptionLines = 2
+	// captions shrink until they fit, but no smaller than this
+	minCaptionSize = 14
+	captionQuality = 90
+)
+
+var captionRegex = regexp.MustCompile(`^(?i)/caption(?:\s+(?P<List>list)|\s+(?P<Template>\S+)` +
+	`(?:\s+["“”](?P<Top>[^"“”]*)["“”])?(?:\s+["“”](?P<Bottom>[^"“”]*)["“”])?)?\s*$`)
+
+var captionFont = mustParseFont(gobold.TTF)
+
+// Directory caption templates are loaded from. Each image in it is a
+// template named after the file. Empty if captions aren't set up
+var captionDir string
+
+// Sets the directory of caption templates, checking that it can be read
+func SetCaptionTemplates(dir string) error {
+	if _, err := os.ReadDir(dir); err != nil {
+		return err
+	}
+	captionDir = dir
+	return nil
+}
+
+// Gets the template names and the files they're in
+func captionTemplates() (map[string]string, error) {
+	entries, err := os.ReadDir(captionDir)
+	if err != nil {
+		return nil, err
+	}
+	templates := make(map[string]string)
+	for _, entry := range entries {
+		ext := strings.ToLower(filepath.Ext(entry.Name()))
+		if entry.IsDir() || (ext != ".png" && ext != ".jpg" && ext != ".jpeg" && ext != ".gif") {
+			continue
+		}
+		name := strings.ToLower(strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name())))
+		templates[name] = filepath.Join(captionDir, entry.Name())
+	}
+	return templates, nil
+}
+
+func loadCaptionTemplate(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	img, _, err := image.Decode(file)
+	return img, err
+}
+
+// Picks the biggest size the text fits across the image at, within
+// maxCaptionLines lines
+func fitCaption(text string, width int, height int) (font.Face, []string) {
+	for size := height / 7; ; size -= 2 {
+		if size < minCaptionSize {
+			size = minCaptionSize
+		}
+		face := newFace(captionFont, float64(size))
+		lines := wrapText(face, text, width)
+		if len(lines) <= maxCaptionLines || size == minCaptionSize {
+			return face, lines
+		}
+	}
+}
+
+// Draws a line centered at the given baseline, white with a black outline
+func drawOutlined(img draw.Image, face font.Face, line string, baseline int) {
+	width := font.MeasureString(face, line).Ceil()
+	x := (img.Bounds().Dx() - width) / 2
+	outline := face.Metrics().Height.Ceil() / 16
+	if outline < 1 {
+		outline = 1
+	}
+	d := font.Drawer{Dst: img, Src: image.NewUniform(color.Black), Face: face}
+	for dy := -outline; dy <= outline; dy++ {
+		for dx := -outline; dx <= outline; dx++ {
+			if dx*dx+dy*dy > outline*outline {
+				continue
+			}
+			d.Dot = fixed.P(img.Bounds().Min.X+x+dx, baseline+dy)
+			d.DrawString(line)
+		}
+	}
+	d.Src = image.NewUniform(color.White)
+	d.Dot = fixed.P(img.Bounds().Min.X+x, baseline)
+	d.DrawString(line)
+}
+
+// Draws meme text across the top and bottom of the template
+func renderCaption(template image.Image, top string, bottom string) *image.RGBA {
+	bounds := template.Bounds()
+	img := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(img, img.Bounds(), template, bounds.Min, draw.Src)
+
+	margin := img.Bounds().Dx() / 20
+	width := img.Bounds().Dx() - 2*margin
+	if top = strings.ToUpper(strings.TrimSpace(top)); top != "" {
+		face, lines := fitCaption(top, width, img.Bounds().Dy())
+		metrics := face.Metrics()
+		baseline := margin + metrics.Ascent.Ceil()
+		for _, line := range lines {
+			drawOutlined(img, face, line, baseline)
+			baseline += metrics.Height.Ceil()
+		}
+	}
+	if bottom = strings.ToUpper(strings.TrimSpace(bottom)); bottom != "" {
+		face, lines := fitCaption(bottom, width, img.Bounds().Dy())
+		metrics := face.Metrics()
+		baseline := img.Bounds().Dy() - margin - metrics.Descent.Ceil() - (len(lines)-1)*metrics.Height.Ceil()
+		for _, line := range lines {
+			drawOutlined(img, face, line, baseline)
+			baseline += metrics.Height.Ceil()
+		}
+	}
+	return img
+}
+
+func captionCommand(callback srv.Callback, i *srv.Instance) (cont bool) {
+	matches := captionRegex.FindStringSubmatch(callback.Text)
+	if matches == nil {
+		cont = false
+		return
+	}
+	cont = true
+	captureGroups := mapSubexpNames(matches, captionRegex.SubexpNames())
+
+	if captionDir == "" || images == nil {
+		reply(callback, i, "Captions aren't set up for this bot")
+		return
+	}
+	templates, err := captionTemplates()
+	if err != nil {
+		replyError(callback, i, err, "Cannot list caption templates")
+		return
+	}
+
+	if hasGroup(captureGroups, "List") || !hasGroup(captureGroups, "Template") {
+		var names []string
+		for name := range templates {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if len(names) == 0 {
+			reply(callback, i, "There aren't any caption templates yet")
+			return
+		}
+		reply(callback, i, "Templates: "+strings.Join(names, ", ")+
+			"\nUse /caption <template> \"top text\" \"bottom text\"")
+		return
+	}
+
+	name := strings.ToLower(captureGroups["Template"])
+	path, ok := templates[name]
+	if !ok {
+		reply(callback, i, fmt.Sprintf("There's no template called '%s' (/caption list)", name))
+		return
+	}
+	if !hasGroup(captureGroups, "Top") && !hasGroup(captureGroups, "Bottom") {
+		reply(callback, i, "Put the text in quotes: /caption "+name+" \"top text\" \"bottom text\"")
+		return
+	}
+
+	template, err := loadCaptionTemplate(path)
+	if err != nil {
+		replyError(callback, i, err, "Cannot load caption template")
+		return
+	}
+	var buf bytes.Buffer
+	top := outgoingText(callback.GroupID, i, captureGroups["Top"])
+	bottom := outgoingText(callback.GroupID, i, captureGroups["Bottom"])
+	img := renderCaption(template, top, bottom)
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: captionQuality}); err != nil {
+		replyError(callback, i, err, "Cannot encode caption")
+		return
+	}
+	url, err := images.Upload(buf.Bytes(), "image/jpeg")
+	if err != nil {
+		replyError(callback, i, err, "Cannot upload caption")
+		return
+	}
+	msg := newMessage(callback)
+	msg.Picture = url
+	send(callback.GroupID, i, msg)
+	return
+}
@@ -0,0 +1,107 @@
+package meme
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+func TestCaptionCommands(t *testing.T) {
+	tests := []struct {
+		text string
+		// captures expected, nil if it shouldn't match
+		want map[string]string
+	}{
+		{"/caption", map[string]string{}},
+		{"/caption list", map[string]string{"List": "list"}},
+		{`/caption drake "tests" "no tests"`, map[string]string{"Template": "drake", "Top": "tests", "Bottom": "no tests"}},
+		{`/caption Drake “curly” “quotes”`, map[string]string{"Template": "Drake", "Top": "curly", "Bottom": "quotes"}},
+		{`/caption drake "top only"`, map[string]string{"Template": "drake", "Top": "top only"}},
+		{`/caption drake "" "bottom only"`, map[string]string{"Template": "drake", "Bottom": "bottom only"}},
+		{"/caption drake top text", nil},
+		{"/captions", nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.text, func(t *testing.T) {
+			matches := captionRegex.FindStringSubmatch(tc.text)
+			if (matches == nil) != (tc.want == nil) {
+				t.Fatalf("matched is %v, want %v", matches != nil, tc.want != nil)
+			}
+			if matches == nil {
+				return
+			}
+			captures := mapSubexpNames(matches, captionRegex.SubexpNames())
+			for _, group := range []string{"List", "Template", "Top", "Bottom"} {
+				if captures[group] != tc.want[group] {
+					t.Errorf("%s is %q, want %q", group, captures[group], tc.want[group])
+				}
+			}
+		})
+	}
+}
+
+func TestFitCaption(t *testing.T) {
+	const width, height = 360, 280
+	largest := newFace(captionFont, float64(height/7)).Metrics().Height
+	smallest := newFace(captionFont, minCaptionSize).Metrics().Height
+	tests := []struct {
+		name string
+		text string
+		// lines the text should come out as
+		lines int
+		// whether it has to shrink, and whether all the way to the minimum
+		shrunk, minimum bool
+	}{
+		{"fits", "ONE DOES NOT", 1, false, false},
+		{"wraps", "ONE DOES NOT SIMPLY WALK", 2, false, false},
+		{"shrinks to fit two lines", "ONE DOES NOT SIMPLY WALK INTO MORDOR WITHOUT TESTS", 2, true, false},
+		{"long word", strings.Repeat("A", 40), 2, true, false},
+		{"too long for two lines", strings.Repeat("WORDS ", 60), 0, true, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			face, lines := fitCaption(tc.text, width, height)
+			size := face.Metrics().Height
+			if tc.lines > 0 && len(lines) != tc.lines {
+				t.Errorf("wrapped onto %d lines %q, want %d", len(lines), lines, tc.lines)
+			}
+			if !tc.minimum && len(lines) > maxCaptionLines {
+				t.Errorf("wrapped onto %d lines, want at most %d", len(lines), maxCaptionLines)
+			}
+			if shrunk := size < largest; shrunk != tc.shrunk {
+				t.Errorf("shrunk is %v, want %v", shrunk, tc.shrunk)
+			}
+			if minimum := size == smallest; minimum != tc.minimum {
+				t.Errorf("at the minimum size is %v, want %v", minimum, tc.minimum)
+			}
+			for _, line := range lines {
+				if font.MeasureString(face, line) > fixed.I(width) {
+					t.Errorf("line %q is wider than %d", line, width)
+				}
+			}
+			if got := strings.ReplaceAll(strings.Join(lines, ""), " ", ""); got != strings.ReplaceAll(tc.text, " ", "") {
+				t.Errorf("lines %q lost some of the text", lines)
+			}
+		})
+	}
+}
+
+// A plain template so the golden image doesn't depend on a file
+func captionBackground() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 400, 300))
+	for y := 0; y < 300; y++ {
+		for x := 0; x < 400; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 255 / 400), G: 96, B: uint8(y * 255 / 300), A: 255})
+		}
+	}
+	return img
+}
+
+func TestRenderCaptionGolden(t *testing.T) {
+	img := renderCaption(captionBackground(), "when the tests pass", "on the first try, which never happens to anyone")
+	checkGolden(t, "caption", img)
+}
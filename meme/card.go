@@ -0,0 +1,229 @@
+package meme
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"strings"
+	"unicode/utf8"
+
+	srv "github.com/ethanzeigler/groupme/botserver"
+	"github.com/ethanzeigler/groupme/gmbots/adapter"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goitalic"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	cardWidth   = 800
+	cardPadding = 60
+	// width of the stripe down the left side
+	cardStripe    = 12
+	cardQuoteSize = 36
+	cardNameSize  = 28
+	cardDateSize  = 22
+	// most lines of quote drawn before it's cut off
+	maxCardLines = 12
+)
+
+// Hosts images so they can be posted. adapter.ImageService in production
+type ImageUploader interface {
+	Upload(image []byte, contentType string) (string, error)
+}
+
+// Where rendered images are uploaded. Nil if images aren't set up
+var images ImageUploader
+
+// Sets where rendered images are uploaded
+func SetImageUploader(uploader ImageUploader) {
+	images = uploader
+}
+
+// Colors of a quote card
+type cardTheme struct {
+	Background color.Color
+	Text       color.Color
+	// quote mark and stripe
+	Accent color.Color
+	// date line
+	Muted color.Color
+}
+
+// Themes groups can pick with card_theme
+var cardThemes = map[string]cardTheme{
+	"light": {
+		Background: color.RGBA{0xfa, 0xfa, 0xfa, 0xff},
+		Text:       color.RGBA{0x21, 0x21, 0x21, 0xff},
+		Accent:     color.RGBA{0x00, 0xaf, 0xf0, 0xff},
+		Muted:      color.RGBA{0x75, 0x75, 0x75, 0xff},
+	},
+	"dark": {
+		Background: color.RGBA{0x1e, 0x1f, 0x24, 0xff},
+		Text:       color.RGBA{0xf0, 0xf0, 0xf0, 0xff},
+		Accent:     color.RGBA{0xff, 0xb3, 0x00, 0xff},
+		Muted:      color.RGBA{0x9e, 0x9e, 0x9e, 0xff},
+	},
+	"groupme": {
+		Background: color.RGBA{0x00, 0xaf, 0xf0, 0xff},
+		Text:       color.RGBA{0xff, 0xff, 0xff, 0xff},
+		Accent:     color.RGBA{0x00, 0x6d, 0x96, 0xff},
+		Muted:      color.RGBA{0xd6, 0xf3, 0xff, 0xff},
+	},
+}
+
+const defaultCardTheme = "light"
+
+var (
+	cardRegular = mustParseFont(goregular.TTF)
+	cardItalic  = mustParseFont(goitalic.TTF)
+	cardBold    = mustParseFont(gobold.TTF)
+)
+
+func mustParseFont(ttf []byte) *opentype.Font {
+	f, err := opentype.Parse(ttf)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// Creates a face of the font. Faces aren't safe to share between
+// goroutines, so every render makes its own
+func newFace(f *opentype.Font, size float64) font.Face {
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		panic(err)
+	}
+	return face
+}
+
+// Returns true if the group's theme exists. Empty uses the default
+func ValidCardTheme(name string) bool {
+	if name == "" {
+		return true
+	}
+	_, ok := cardThemes[strings.ToLower(name)]
+	return ok
+}
+
+func groupCardTheme(groupID string) cardTheme {
+	if theme, ok := cardThemes[strings.ToLower(groups[groupID].CardTheme)]; ok {
+		return theme
+	}
+	return cardThemes[defaultCardTheme]
+}
+
+// Splits text into lines no wider than width. Words too long for a line
+// of their own are broken up
+func wrapText(face font.Face, text string, width int) []string {
+	limit := fixed.I(width)
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(text) {
+		for font.MeasureString(face, word) > limit {
+			// break the word at the last rune that fits
+			_, first := utf8.DecodeRuneInString(word)
+			cut := len(word)
+			for cut > first && font.MeasureString(face, word[:cut]) > limit {
+				_, size := utf8.DecodeLastRuneInString(word[:cut])
+				cut -= size
+			}
+			if line != "" {
+				lines = append(lines, line)
+				line = ""
+			}
+			lines = append(lines, word[:cut])
+			word = word[cut:]
+		}
+		if line == "" {
+			line = word
+		} else if font.MeasureString(face, line+" "+word) <= limit {
+			line += " " + word
+		} else {
+			lines = append(lines, line)
+			line = word
+		}
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// Draws a quote card. The same quote and theme always give the same image
+func renderCard(view quoteView, theme cardTheme) image.Image {
+	quoteFace := newFace(cardItalic, cardQuoteSize)
+	markFace := newFace(cardBold, 96)
+	nameFace := newFace(cardBold, cardNameSize)
+	dateFace := newFace(cardRegular, cardDateSize)
+
+	textLeft := cardStripe + cardPadding
+	textWidth := cardWidth - textLeft - cardPadding
+	lines := wrapText(quoteFace, view.Quote, textWidth)
+	if len(lines) > maxCardLines {
+		lines = append(lines[:maxCardLines-1], lines[maxCardLines-1]+"…")
+	}
+	lineHeight := cardQuoteSize * 4 / 3
+
+	// quote mark, quote, gap, name, date
+	height := cardPadding + 50 + len(lines)*lineHeight + 30 + cardNameSize + 12
+	if !view.Date.IsZero() {
+		height += cardDateSize + 12
+	}
+	height += cardPadding
+
+	img := image.NewRGBA(image.Rect(0, 0, cardWidth, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(theme.Background), image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(0, 0, cardStripe, height), image.NewUniform(theme.Accent), image.Point{}, draw.Src)
+
+	text := func(face font.Face, c color.Color, x, y int, s string) {
+		d := font.Drawer{Dst: img, Src: image.NewUniform(c), Face: face, Dot: fixed.P(x, y)}
+		d.DrawString(s)
+	}
+
+	y := cardPadding + 50
+	text(markFace, theme.Accent, textLeft-8, y, "“")
+	for _, line := range lines {
+		y += lineHeight
+		text(quoteFace, theme.Text, textLeft, y, line)
+	}
+	y += 30 + cardNameSize
+	text(nameFace, theme.Text, textLeft, y, "— "+capitalize(view.Name))
+	if !view.Date.IsZero() {
+		y += cardDateSize + 12
+		text(dateFace, theme.Muted, textLeft, y, view.Date.Format("January 2, 2006"))
+	}
+	return img
+}
+
+// Renders a card as a PNG and uploads it, returning the URL it can be posted with
+func uploadCard(uploader ImageUploader, view quoteView, theme cardTheme) (string, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, renderCard(view, theme)); err != nil {
+		return "", err
+	}
+	return uploader.Upload(buf.Bytes(), "image/png")
+}
+
+// Renders the quote as a card and posts it to the group
+func postCard(callback srv.Callback, i *srv.Instance, quote adapter.Quote) {
+	if images == nil {
+		reply(callback, i, "Quote cards aren't set up for this bot")
+		return
+	}
+	view := newQuoteView(quote, callback.GroupID)
+	view.Quote = outgoingText(callback.GroupID, i, view.Quote)
+	url, err := uploadCard(images, view, groupCardTheme(callback.GroupID))
+	if err != nil {
+		replyError(callback, i, err, "Cannot upload quote card")
+		return
+	}
+	msg := newMessage(callback)
+	msg.Picture = url
+	send(callback.GroupID, i, msg)
+}
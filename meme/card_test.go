@@ -0,0 +1,160 @@
+package meme
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"image"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+var goldenCards = []struct {
+	name  string
+	view  quoteView
+	theme string
+}{
+	{
+		name:  "card_light",
+		view:  quoteView{Name: "ethan", Quote: "I'll fix it tomorrow", Date: time.Date(2019, time.May, 1, 17, 30, 0, 0, time.UTC)},
+		theme: "light",
+	},
+	{
+		name:  "card_dark_undated",
+		view:  quoteView{Name: "élodie", Quote: "Nobody reads the long ones, so this one keeps going well past the end of the first line"},
+		theme: "dark",
+	},
+	{
+		name:  "card_groupme_long_word",
+		view:  quoteView{Name: "bob", Quote: strings.Repeat("a", 80)},
+		theme: "groupme",
+	},
+}
+
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok {
+		return rgba
+	}
+	rgba := image.NewRGBA(img.Bounds())
+	draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+	return rgba
+}
+
+// Compares an image to testdata/<name>.png, rewriting it with -update
+func checkGolden(t *testing.T, name string, got *image.RGBA) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".png")
+	if *updateGolden {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, got); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("%v. Run the tests with -update to create it", err)
+	}
+	defer file.Close()
+	decoded, err := png.Decode(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := toRGBA(decoded)
+	if got.Bounds() != want.Bounds() {
+		t.Fatalf("bounds are %v, want %v", got.Bounds(), want.Bounds())
+	}
+	if !bytes.Equal(got.Pix, want.Pix) {
+		t.Errorf("image differs from %s. Run the tests with -update if the change is intended", path)
+	}
+}
+
+func TestRenderCardGolden(t *testing.T) {
+	for _, tc := range goldenCards {
+		t.Run(tc.name, func(t *testing.T) {
+			checkGolden(t, tc.name, toRGBA(renderCard(tc.view, cardThemes[tc.theme])))
+		})
+	}
+}
+
+func TestRenderCardDeterministic(t *testing.T) {
+	view := goldenCards[0].view
+	first := toRGBA(renderCard(view, cardThemes["light"]))
+	second := toRGBA(renderCard(view, cardThemes["light"]))
+	if !bytes.Equal(first.Pix, second.Pix) {
+		t.Error("rendering the same card twice gave different images")
+	}
+}
+
+// Keeps what it's given instead of hosting it
+type fakeUploader struct {
+	image       []byte
+	contentType string
+	url         string
+	err         error
+}
+
+func (f *fakeUploader) Upload(image []byte, contentType string) (string, error) {
+	f.image = image
+	f.contentType = contentType
+	return f.url, f.err
+}
+
+func TestUploadCard(t *testing.T) {
+	uploader := &fakeUploader{url: "https://i.groupme.com/800x300.png.abc"}
+	url, err := uploadCard(uploader, goldenCards[0].view, cardThemes["light"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url != uploader.url {
+		t.Errorf("url is %q, want %q", url, uploader.url)
+	}
+	if uploader.contentType != "image/png" {
+		t.Errorf("content type is %q, want image/png", uploader.contentType)
+	}
+	img, err := png.Decode(bytes.NewReader(uploader.image))
+	if err != nil {
+		t.Fatalf("uploaded image isn't a PNG: %v", err)
+	}
+	if img.Bounds().Dx() != cardWidth {
+		t.Errorf("card is %d wide, want %d", img.Bounds().Dx(), cardWidth)
+	}
+}
+
+func TestUploadCardError(t *testing.T) {
+	uploadErr := errors.New("image service responded 503 Service Unavailable")
+	_, err := uploadCard(&fakeUploader{err: uploadErr}, goldenCards[0].view, cardThemes["light"])
+	if !errors.Is(err, uploadErr) {
+		t.Errorf("err is %v, want %v", err, uploadErr)
+	}
+}
+
+func TestCapitalize(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"", ""},
+		{"ethan", "Ethan"},
+		{"Ethan", "Ethan"},
+		{"élodie", "Élodie"},
+		{"ßtraße", "ßtraße"},
+		{"1st", "1st"},
+		{"\xffbad", "\xffbad"},
+	}
+	for _, tc := range tests {
+		if got := capitalize(tc.in); got != tc.want {
+			t.Errorf("capitalize(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
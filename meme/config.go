@@ -0,0 +1,56 @@
+package meme
+
+import (
+	"time"
+
+	"github.com/ethanzeigler/groupme/gmbots/filter"
+)
+
+// Settings for a single group the meme machine listens to
+type Group struct {
+	GroupID   string
+	BotID     string
+	BotUserID string
+	IsAlpha   bool
+	// user IDs allowed to manage the bot in this group
+	Admins []string
+	// sender IDs of other bots whose posts may trigger commands
+	AllowedBots []string
+	RateLimits  RateLimits
+	// how long "who said it?" takes guesses
+	TriviaWindow time.Duration
+	// zone quote dates are shown and backdated in. Nil for UTC
+	TimeZone *time.Location
+	// text/template quotes are shown with until the group picks its own.
+	// Empty for DefaultQuoteFormat
+	QuoteFormat string
+	// colors of quote cards, one of cardThemes. Empty for light
+	CardTheme string
+	// quotes recorded by anyone but an admin wait for approval
+	Moderated bool
+	// random quotes favor ones with higher scores
+	WeightedRandom bool
+	// what happens to quotes and posts the content filter matches
+	Filter filter.Policy
+}
+
+// Configured groups by group ID
+var groups map[string]Group
+
+// Gets the time zone of the group
+func groupLocation(groupID string) *time.Location {
+	if loc := groups[groupID].TimeZone; loc != nil {
+		return loc
+	}
+	return time.UTC
+}
+
+// Returns true if the sender of the callback is an admin of its group
+func isAdmin(groupID string, userID string) bool {
+	for _, admin := range groups[groupID].Admins {
+		if admin == userID {
+			return true
+		}
+	}
+	return false
+}
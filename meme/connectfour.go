@@ -0,0 +1,419 @@
+package meme
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	srv "github.com/ethanzeigler/groupme/botserver"
+	"github.com/ethanzeigler/groupme/gmbots/adapter"
+)
+
+const (
+	c4Rows = 6
+	c4Cols = 7
+	// pieces in a row needed to win
+	c4Connect = 4
+	c4Empty   = '.'
+	c4Red     = 'R'
+	c4Yellow  = 'Y'
+	// the player to move forfeits after this long
+	c4MoveTimeout = 24 * time.Hour
+)
+
+// "/c4 3" is how memes were asked for before there was a game, so it still works
+var c4MemeRegex = regexp.MustCompile(`^(?i)(?:.*\s)?/c4(?:\s+meme)?(?:\s+(?P<Count>\d+))?\s*$`)
+
+var c4Regex = regexp.MustCompile(`^(?i)(?:.*\s)?/c4\s+(?P<Subcommand>challenge|drop|board|forfeit|leaderboard)` +
+	`(?:\s+(?P<Argument>.+?))?\s*$`)
+
+// Moves are read, checked and saved one at a time in each group. Groups
+// don't wait on each other's database calls
+var c4Locks = struct {
+	sync.Mutex
+	groups map[string]*sync.Mutex
+}{groups: make(map[string]*sync.Mutex)}
+
+// Gets the lock for the group's game
+func c4Lock(groupID string) *sync.Mutex {
+	c4Locks.Lock()
+	defer c4Locks.Unlock()
+	lock, ok := c4Locks.groups[groupID]
+	if !ok {
+		lock = &sync.Mutex{}
+		c4Locks.groups[groupID] = lock
+	}
+	return lock
+}
+
+type c4Board [c4Rows][c4Cols]byte
+
+func newC4Board() (b c4Board) {
+	for row := range b {
+		for col := range b[row] {
+			b[row][col] = c4Empty
+		}
+	}
+	return
+}
+
+// Reads a board saved with String
+func parseC4Board(s string) c4Board {
+	b := newC4Board()
+	for n := 0; n < len(s) && n < c4Rows*c4Cols; n++ {
+		b[n/c4Cols][n%c4Cols] = s[n]
+	}
+	return b
+}
+
+func (b c4Board) String() string {
+	var sb strings.Builder
+	for _, row := range b {
+		sb.Write(row[:])
+	}
+	return sb.String()
+}
+
+// Drops a piece into the column, 0 indexed. Returns the row it landed in,
+// or false if the column is full.
+func (b *c4Board) drop(col int, piece byte) (row int, ok bool) {
+	for row = c4Rows - 1; row >= 0; row-- {
+		if b[row][col] == c4Empty {
+			b[row][col] = piece
+			return row, true
+		}
+	}
+	return -1, false
+}
+
+// Returns true if the piece at the given cell is part of a winning line
+func (b c4Board) wins(row, col int) bool {
+	piece := b[row][col]
+	directions := [][2]int{{0, 1}, {1, 0}, {1, 1}, {1, -1}}
+	for _, d := range directions {
+		count := 1
+		// walk both ways from the piece
+		for _, sign := range []int{1, -1} {
+			r, c := row+d[0]*sign, col+d[1]*sign
+			for r >= 0 && r < c4Rows && c >= 0 && c < c4Cols && b[r][c] == piece {
+				count++
+				r, c = r+d[0]*sign, c+d[1]*sign
+			}
+		}
+		if count >= c4Connect {
+			return true
+		}
+	}
+	return false
+}
+
+func (b c4Board) full() bool {
+	for col := 0; col < c4Cols; col++ {
+		if b[0][col] == c4Empty {
+			return false
+		}
+	}
+	return true
+}
+
+// Draws the board as an emoji grid with column numbers on top
+func (b c4Board) render() string {
+	var sb strings.Builder
+	sb.WriteString("1️⃣2️⃣3️⃣4️⃣5️⃣6️⃣7️⃣\n")
+	for _, row := range b {
+		for _, cell := range row {
+			switch cell {
+			case c4Red:
+				sb.WriteString("🔴")
+			case c4Yellow:
+				sb.WriteString("🟡")
+			default:
+				sb.WriteString("⚪")
+			}
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// Returns true once the challenged player has made a move
+func c4Accepted(game *adapter.C4Game) bool {
+	return strings.IndexByte(game.Board, c4Yellow) >= 0
+}
+
+// Gets the ID of the first user @mentioned in the message, if any
+func mentionedUser(callback srv.Callback) (string, bool) {
+	for _, attachment := range callback.Attachments {
+		if attachment.Type == "mentions" && len(attachment.UserIDs) > 0 {
+			return attachment.UserIDs[0], true
+		}
+	}
+	return "", false
+}
+
+// Name of the player whose turn it is
+func c4PlayerToMove(game *adapter.C4Game) string {
+	if game.RedTurn {
+		return game.RedName
+	}
+	return game.YellowName
+}
+
+func connectFour(callback srv.Callback, i *srv.Instance) (cont bool) {
+	matches := c4Regex.FindStringSubmatch(callback.Text)
+	if matches == nil {
+		cont = false
+		return
+	}
+	cont = true
+	captureGroups := mapSubexpNames(matches, c4Regex.SubexpNames())
+	argument := strings.TrimSpace(captureGroups["Argument"])
+
+	lock := c4Lock(callback.GroupID)
+	lock.Lock()
+	defer lock.Unlock()
+	game, timedOut, err := loadC4Game(callback, i)
+	if err != nil {
+		replyError(callback, i, err, "Cannot load Connect 4 game")
+		return
+	}
+	if timedOut {
+		// the group was just told the game is over
+		return
+	}
+
+	switch strings.ToLower(captureGroups["Subcommand"]) {
+	case "challenge":
+		c4Challenge(callback, i, game, argument)
+	case "drop":
+		c4Drop(callback, i, game, argument)
+	case "forfeit":
+		c4Forfeit(callback, i, game)
+	case "leaderboard":
+		c4Leaderboard(callback, i)
+	case "board":
+		if game == nil {
+			reply(callback, i, "No game going. Start one with /c4 challenge @<name>")
+		} else {
+			reply(callback, i, renderC4Game(game)+fmt.Sprintf("%s's turn", c4PlayerToMove(game)))
+		}
+	}
+	return
+}
+
+// Posts random Connect 4 memes. This was all /c4 did before there was a game
+func c4Meme(callback srv.Callback, i *srv.Instance) (cont bool) {
+	matches := c4MemeRegex.FindStringSubmatch(callback.Text)
+	if matches == nil {
+		cont = false
+		return
+	}
+	cont = true
+	msg := newMessage(callback)
+	count, err := strconv.Atoi(mapSubexpNames(matches, c4MemeRegex.SubexpNames())["Count"])
+	if err != nil || count < 1 {
+		count = 1
+	} else if count > 9 {
+		count = 9
+	}
+	for n := 0; n < count; n++ {
+		msg.Picture = c4Images[rand.Intn(len(c4Images))]
+		if count == 1 {
+			send(callback.GroupID, i, msg)
+		} else if err := postSync(callback.GroupID, i, msg); errors.Is(err, errOverBudget) {
+			// the rest would be dropped too
+			break
+		}
+	}
+	return
+}
+
+// Gets the group's game. If the player to move has timed out, the game
+// is ended, the group is told and timedOut is true
+func loadC4Game(callback srv.Callback, i *srv.Instance) (game *adapter.C4Game, timedOut bool, err error) {
+	game, err = quoteDB.GetC4Game(callback.GroupID)
+	if err != nil || game == nil {
+		return game, false, err
+	}
+	if time.Since(game.LastMove) < c4MoveTimeout {
+		return game, false, nil
+	}
+
+	if !c4Accepted(game) {
+		// the challenge was never taken up
+		err = quoteDB.DeleteC4Game(game.GroupID)
+		if err == nil {
+			reply(callback, i, fmt.Sprintf("%s never answered %s's challenge", game.YellowName, game.RedName))
+		}
+		return nil, err == nil, err
+	}
+	winnerID, winner, loser := game.YellowID, game.YellowName, game.RedName
+	if !game.RedTurn {
+		winnerID, winner, loser = game.RedID, game.RedName, game.YellowName
+	}
+	err = quoteDB.FinishC4Game(*game, winnerID, false)
+	if err == nil {
+		reply(callback, i, fmt.Sprintf("%s took too long and forfeits. %s wins!", loser, winner))
+	}
+	return nil, err == nil, err
+}
+
+func c4Challenge(callback srv.Callback, i *srv.Instance, game *adapter.C4Game, argument string) {
+	if game != nil {
+		reply(callback, i, fmt.Sprintf("%s and %s are already playing", game.RedName, game.YellowName))
+		return
+	}
+	// names can be changed to anyone's, so the opponent is whoever the
+	// mention points at
+	opponentID, ok := mentionedUser(callback)
+	opponent := strings.TrimSpace(strings.TrimPrefix(argument, "@"))
+	if !ok || opponent == "" {
+		reply(callback, i, "Who do you want to play? @mention them: /c4 challenge @<name>")
+		return
+	}
+	if opponentID == callback.SenderID {
+		reply(callback, i, "You can't play yourself")
+		return
+	}
+
+	// the challenger goes first
+	game = &adapter.C4Game{
+		GroupID:    callback.GroupID,
+		Board:      newC4Board().String(),
+		RedID:      callback.SenderID,
+		RedName:    callback.Name,
+		YellowID:   opponentID,
+		YellowName: opponent,
+		RedTurn:    true,
+		LastMove:   time.Now(),
+	}
+	if err := quoteDB.SaveC4Game(*game); err != nil {
+		replyError(callback, i, err, "Cannot save Connect 4 game")
+		return
+	}
+	reply(callback, i, renderC4Game(game)+
+		fmt.Sprintf("%s (🔴) challenged %s (🟡). %s goes first with /c4 drop <1-7>",
+			game.RedName, game.YellowName, game.RedName))
+}
+
+func c4Drop(callback srv.Callback, i *srv.Instance, game *adapter.C4Game, argument string) {
+	if game == nil {
+		reply(callback, i, "No game going. Start one with /c4 challenge @<name>")
+		return
+	}
+
+	var piece byte
+	switch {
+	case callback.SenderID == game.RedID:
+		piece = c4Red
+	case callback.SenderID == game.YellowID:
+		piece = c4Yellow
+	default:
+		reply(callback, i, fmt.Sprintf("You're not in this game. %s and %s are playing", game.RedName, game.YellowName))
+		return
+	}
+	if (piece == c4Red) != game.RedTurn {
+		reply(callback, i, fmt.Sprintf("It's %s's turn", c4PlayerToMove(game)))
+		return
+	}
+
+	col, err := strconv.Atoi(argument)
+	if err != nil || col < 1 || col > c4Cols {
+		reply(callback, i, "Pick a column from 1 to 7")
+		return
+	}
+	board := parseC4Board(game.Board)
+	row, ok := board.drop(col-1, piece)
+	if !ok {
+		reply(callback, i, "That column is full")
+		return
+	}
+	if piece == c4Yellow {
+		// the name from the challenge is whatever was typed after the @
+		game.YellowName = callback.Name
+	}
+	game.Board = board.String()
+
+	if board.wins(row, col-1) {
+		if err := quoteDB.FinishC4Game(*game, callback.SenderID, false); err != nil {
+			replyError(callback, i, err, "Cannot finish Connect 4 game")
+			return
+		}
+		reply(callback, i, board.render()+fmt.Sprintf("%s wins!", callback.Name))
+		return
+	}
+	if board.full() {
+		if err := quoteDB.FinishC4Game(*game, "", true); err != nil {
+			replyError(callback, i, err, "Cannot finish Connect 4 game")
+			return
+		}
+		reply(callback, i, board.render()+"It's a draw")
+		return
+	}
+
+	game.RedTurn = !game.RedTurn
+	game.LastMove = time.Now()
+	if err := quoteDB.SaveC4Game(*game); err != nil {
+		replyError(callback, i, err, "Cannot save Connect 4 game")
+		return
+	}
+	reply(callback, i, board.render()+fmt.Sprintf("%s's turn", c4PlayerToMove(game)))
+}
+
+func c4Forfeit(callback srv.Callback, i *srv.Instance, game *adapter.C4Game) {
+	if game == nil {
+		reply(callback, i, "No game going")
+		return
+	}
+	var err error
+	switch {
+	case !c4Accepted(game) && (callback.SenderID == game.RedID || callback.SenderID == game.YellowID):
+		// nobody has played the challenge yet, so nobody loses
+		err = quoteDB.DeleteC4Game(game.GroupID)
+		if err == nil {
+			reply(callback, i, "Challenge called off")
+		}
+	case callback.SenderID == game.RedID:
+		err = quoteDB.FinishC4Game(*game, game.YellowID, false)
+		if err == nil {
+			reply(callback, i, fmt.Sprintf("%s forfeits. %s wins!", game.RedName, game.YellowName))
+		}
+	case callback.SenderID == game.YellowID:
+		err = quoteDB.FinishC4Game(*game, game.RedID, false)
+		if err == nil {
+			reply(callback, i, fmt.Sprintf("%s forfeits. %s wins!", game.YellowName, game.RedName))
+		}
+	default:
+		reply(callback, i, "You're not in this game")
+	}
+	if err != nil {
+		replyError(callback, i, err, "Cannot end Connect 4 game")
+	}
+}
+
+func c4Leaderboard(callback srv.Callback, i *srv.Instance) {
+	stats, err := quoteDB.GetC4Leaderboard(callback.GroupID, 10)
+	if err != nil {
+		replyError(callback, i, err, "Cannot load Connect 4 leaderboard")
+		return
+	}
+	if len(stats) == 0 {
+		reply(callback, i, "Nobody has finished a game yet")
+		return
+	}
+	var sb strings.Builder
+	for n, s := range stats {
+		sb.WriteString(fmt.Sprintf("%d. %s - %dW %dL %dD\n", n+1, s.Name, s.Wins, s.Losses, s.Draws))
+	}
+	reply(callback, i, sb.String())
+}
+
+// Renders the game's board
+func renderC4Game(game *adapter.C4Game) string {
+	return parseC4Board(game.Board).render()
+}
@@ -0,0 +1,217 @@
+package meme
+
+import (
+	"strings"
+	"testing"
+
+	srv "github.com/ethanzeigler/groupme/botserver"
+)
+
+// Builds a board from rows drawn top to bottom
+func boardOf(rows ...string) c4Board {
+	return parseC4Board(strings.Join(rows, ""))
+}
+
+func TestC4Wins(t *testing.T) {
+	tests := []struct {
+		name     string
+		board    c4Board
+		row, col int
+		want     bool
+	}{
+		{"horizontal", boardOf(
+			".......",
+			".......",
+			".......",
+			".......",
+			".......",
+			"RRRR...",
+		), 5, 1, true},
+		{"horizontal at the edge", boardOf(
+			".......",
+			".......",
+			".......",
+			".......",
+			".......",
+			"YYY.RRR",
+		), 5, 6, false},
+		{"vertical", boardOf(
+			".......",
+			".......",
+			"Y......",
+			"Y......",
+			"Y......",
+			"Y......",
+		), 2, 0, true},
+		{"vertical three", boardOf(
+			".......",
+			".......",
+			".......",
+			"Y......",
+			"Y......",
+			"Y......",
+		), 3, 0, false},
+		{"diagonal up", boardOf(
+			".......",
+			".......",
+			"...R...",
+			"..RY...",
+			".RYY...",
+			"RYYY...",
+		), 2, 3, true},
+		{"diagonal down from the middle", boardOf(
+			".......",
+			".......",
+			"R......",
+			"YR.....",
+			"YYR....",
+			"YYYR...",
+		), 4, 2, true},
+		{"broken line", boardOf(
+			".......",
+			".......",
+			".......",
+			".......",
+			".......",
+			"RR.RR..",
+		), 5, 4, false},
+		{"five in a row", boardOf(
+			".......",
+			".......",
+			".......",
+			".......",
+			".......",
+			"RRRRR..",
+		), 5, 4, true},
+		{"other player's line", boardOf(
+			".......",
+			".......",
+			".......",
+			".......",
+			"R......",
+			"YYYY...",
+		), 4, 0, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.board.wins(tc.row, tc.col); got != tc.want {
+				t.Errorf("wins(%d, %d) = %v, want %v", tc.row, tc.col, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestC4Full(t *testing.T) {
+	tests := []struct {
+		name  string
+		board c4Board
+		want  bool
+	}{
+		{"empty", newC4Board(), false},
+		{"one column open", boardOf(
+			"RYRYRY.",
+			"RYRYRYR",
+			"YRYRYRY",
+			"YRYRYRY",
+			"RYRYRYR",
+			"RYRYRYR",
+		), false},
+		{"full", boardOf(
+			"RYRYRYR",
+			"RYRYRYR",
+			"YRYRYRY",
+			"YRYRYRY",
+			"RYRYRYR",
+			"RYRYRYR",
+		), true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.board.full(); got != tc.want {
+				t.Errorf("full() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestC4Drop(t *testing.T) {
+	b := newC4Board()
+	for want := c4Rows - 1; want >= 0; want-- {
+		row, ok := b.drop(3, c4Red)
+		if !ok || row != want {
+			t.Fatalf("drop landed in row %d (%v), want %d", row, ok, want)
+		}
+	}
+	if _, ok := b.drop(3, c4Yellow); ok {
+		t.Error("dropped into a full column")
+	}
+	if got := parseC4Board(b.String()); got != b {
+		t.Error("board changed going through String and parseC4Board")
+	}
+}
+
+func TestC4Commands(t *testing.T) {
+	tests := []struct {
+		text       string
+		meme       bool
+		count      string
+		subcommand string
+	}{
+		{"/c4", true, "", ""},
+		{"/c4 3", true, "3", ""},
+		{"/c4 meme", true, "", ""},
+		{"/C4 MEME 9", true, "9", ""},
+		{"look at this /c4 2", true, "2", ""},
+		{"/c4 drop 4", false, "", "drop"},
+		{"/c4 challenge @Bob", false, "", "challenge"},
+		{"/c4 board", false, "", "board"},
+		{"/c4 leaderboard", false, "", "leaderboard"},
+		{"/c4 dance", false, "", ""},
+		{"/c42", false, "", ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.text, func(t *testing.T) {
+			memeMatches := c4MemeRegex.FindStringSubmatch(tc.text)
+			if (memeMatches != nil) != tc.meme {
+				t.Fatalf("meme match is %v, want %v", memeMatches != nil, tc.meme)
+			}
+			if memeMatches != nil {
+				if got := mapSubexpNames(memeMatches, c4MemeRegex.SubexpNames())["Count"]; got != tc.count {
+					t.Errorf("count is %q, want %q", got, tc.count)
+				}
+			}
+			gameMatches := c4Regex.FindStringSubmatch(tc.text)
+			if (gameMatches != nil) != (tc.subcommand != "") {
+				t.Fatalf("game match is %v, want %v", gameMatches != nil, tc.subcommand != "")
+			}
+			if gameMatches != nil {
+				if got := strings.ToLower(mapSubexpNames(gameMatches, c4Regex.SubexpNames())["Subcommand"]); got != tc.subcommand {
+					t.Errorf("subcommand is %q, want %q", got, tc.subcommand)
+				}
+			}
+		})
+	}
+}
+
+func TestMentionedUser(t *testing.T) {
+	tests := []struct {
+		name        string
+		attachments []srv.Attachment
+		want        string
+		ok          bool
+	}{
+		{"none", nil, "", false},
+		{"image only", []srv.Attachment{{Type: "image", URL: "https://i.groupme.com/a"}}, "", false},
+		{"mention", []srv.Attachment{{Type: "mentions", UserIDs: []string{"42"}, Loci: [][]int{{15, 4}}}}, "42", true},
+		{"first of several", []srv.Attachment{{Type: "image"}, {Type: "mentions", UserIDs: []string{"7", "8"}}}, "7", true},
+		{"empty mention", []srv.Attachment{{Type: "mentions"}}, "", false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := mentionedUser(srv.Callback{Attachments: tc.attachments})
+			if got != tc.want || ok != tc.ok {
+				t.Errorf("mentionedUser = %q, %v, want %q, %v", got, ok, tc.want, tc.ok)
+			}
+		})
+	}
+}
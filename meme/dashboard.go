@@ -0,0 +1,58 @@
+package meme
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	srv "github.com/ethanzeigler/groupme/botserver"
+	"github.com/ethanzeigler/groupme/gmbots/adapter"
+)
+
+// how long a dashboard link works
+const dashboardLinkTTL = 24 * time.Hour
+
+var dashboardRegex = regexp.MustCompile(`^(?i)/quotes\s+dashboard\s*$`)
+
+// Where the dashboard is served and how links get to admins. Unset if
+// the dashboard isn't set up
+var dashboard struct {
+	baseURL   string
+	messenger adapter.DirectMessenger
+}
+
+// Sets the public URL of the dashboard and how links to it are sent
+func SetDashboard(baseURL string, dm adapter.DirectMessenger) {
+	dashboard.baseURL = strings.TrimSuffix(baseURL, "/")
+	dashboard.messenger = dm
+}
+
+// DMs an admin a link to the group's dashboard. Links go by DM since
+// anyone holding one can edit the group's quotes
+func dashboardCommand(callback srv.Callback, i *srv.Instance) (cont bool) {
+	if !dashboardRegex.MatchString(callback.Text) {
+		cont = false
+		return
+	}
+	cont = true
+
+	if dashboard.baseURL == "" || dashboard.messenger == nil {
+		reply(callback, i, "The dashboard isn't set up for this bot")
+		return
+	}
+	token, err := quoteDB.CreateDashboardToken(callback.GroupID, callback.SenderID, dashboardLinkTTL)
+	if err != nil {
+		replyError(callback, i, err, "Cannot create dashboard token")
+		return
+	}
+	link := dashboard.baseURL + "/dashboard/" + token + "/"
+	err = dashboard.messenger.SendDirectMessage(callback.SenderID,
+		"Here's your group's quote dashboard. The link works for 24 hours, don't share it: "+link)
+	if err != nil {
+		replyError(callback, i, err, "Cannot send dashboard link")
+		return
+	}
+	audit(callback, i, "dashboard link", "")
+	reply(callback, i, "Sent you a link to the dashboard")
+	return
+}
@@ -0,0 +1,117 @@
+package meme
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+
+	srv "github.com/ethanzeigler/groupme/botserver"
+	"github.com/ethanzeigler/groupme/gmbots/adapter"
+	"github.com/ethanzeigler/groupme/gmbots/report"
+	"github.com/sirupsen/logrus"
+)
+
+// Shown for errors nothing more specific can be said about
+const unexpectedErrorText = "[Error: Reported to developer]"
+
+// Gets failures to the developer. Nil if errors are only logged
+var reporter *report.Reporter
+
+// DebugNames of hooks by the name of their handler function, used to
+// tell which hook an error came from
+var handlerHooks = make(map[string]string)
+
+// Sets where unexpected errors are reported
+func SetErrorReporter(r *report.Reporter) {
+	reporter = r
+}
+
+func funcName(fn interface{}) string {
+	if f := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()); f != nil {
+		return f.Name()
+	}
+	return ""
+}
+
+// Turns an error into something that can be shown in the group.
+// Details stay in the logs.
+func userMessage(err error) string {
+	switch {
+	case errors.Is(err, adapter.ErrNotFound):
+		return "No quotes found"
+	case errors.Is(err, adapter.ErrInvalidGroupID):
+		return "This group isn't set up for quotes"
+	case errors.Is(err, adapter.ErrConstraint):
+		return "That clashes with something that's already saved"
+	case errors.Is(err, adapter.ErrOptedOut):
+		return "They've asked not to be quoted"
+	case errors.Is(err, adapter.ErrFiltered):
+		return "That quote didn't get past the filter"
+	case errors.Is(err, adapter.ErrUnavailable):
+		return "The quote database is down right now. Try again in a bit"
+	default:
+		return unexpectedErrorText
+	}
+}
+
+// Reports an error with what the callback was doing and returns its
+// incident ID. The hook is found by walking up the stack to a handler
+func reportError(callback srv.Callback, err error, message string) string {
+	incident := report.Incident{
+		Message:  message,
+		Err:      err.Error(),
+		GroupID:  callback.GroupID,
+		SenderID: callback.SenderID,
+		Text:     callback.Text,
+		Stack:    string(debug.Stack()),
+	}
+	pcs := make([]uintptr, 64)
+	// skip runtime.Callers, reportError and replyError
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+	for first := true; ; first = false {
+		frame, more := frames.Next()
+		if first {
+			incident.Where = fmt.Sprintf("%s:%d", frame.Function, frame.Line)
+		}
+		if hook, ok := handlerHooks[frame.Function]; ok {
+			incident.Hook = hook
+			break
+		}
+		if !more {
+			break
+		}
+	}
+	return reporter.Report(incident)
+}
+
+// Logs the error and tells the group what went wrong. Unexpected errors
+// are reported and the group is given the incident ID
+func replyError(callback srv.Callback, i *srv.Instance, err error, message string) {
+	entry := i.Log.WithFields(logrus.Fields{
+		"err":    err.Error(),
+		"group":  callback.GroupID,
+		"sender": callback.SenderID,
+		"text":   callback.Text,
+	})
+	if errors.Is(err, adapter.ErrNotFound) || errors.Is(err, adapter.ErrOptedOut) ||
+		errors.Is(err, adapter.ErrFiltered) {
+		// nothing is broken, someone just asked for something that isn't there
+		// or isn't allowed
+		entry.Debug(message)
+		reply(callback, i, userMessage(err))
+		return
+	}
+
+	text := userMessage(err)
+	if reporter != nil {
+		id := reportError(callback, err, message)
+		entry = entry.WithField("incident", id)
+		if text == unexpectedErrorText {
+			text = fmt.Sprintf("[Error: Reported to developer as incident %s]", id)
+		}
+	}
+	entry.Error(message)
+	reply(callback, i, text)
+}
@@ -0,0 +1,273 @@
+package meme
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	srv "github.com/ethanzeigler/groupme/botserver"
+	"github.com/sirupsen/logrus"
+)
+
+// Rollout stage of a feature
+type stage string
+
+const (
+	// runs everywhere unless a group turns it off
+	stageStable stage = "stable"
+	// off unless a group turns it on
+	stageBeta stage = "beta"
+	// only runs in groups flagged is_alpha
+	stageAlpha stage = "alpha"
+)
+
+// A hook that can be turned on and off per group with /features
+type feature struct {
+	// Name used to refer to the feature in /features
+	Name string
+	// Line shown in /help. Empty to leave it out
+	Help string
+	// Required features can't be disabled
+	Required bool
+	// Stage the feature is registered with. Empty means stable
+	Stage stage
+	// Only group admins may use the feature
+	AdminOnly bool
+	// Matches messages invoking the feature, used for rate limiting.
+	// Nil if the feature isn't rate limited
+	Trigger *regexp.Regexp
+	Hook    srv.BasicHook
+}
+
+// All features of the channel, in the order their hooks are registered
+var features []*feature
+
+var featuresRegex = regexp.MustCompile(`^(?i)/features(?:\s+(?P<Action>enable|disable)\s+(?P<Feature>\S+)|` +
+	`\s+promote\s+(?P<PromoteFeature>\S+)\s+(?P<Stage>stable|beta|alpha))?\s*$`)
+
+// Caches the feature flags of each group so the database
+// isn't hit on every callback. Never held while loading
+var flagCache = struct {
+	sync.Mutex
+	groups map[string]map[string]bool
+	// bumped when flags are dropped, so loads that started before
+	// don't put back what was dropped
+	generation uint64
+}{groups: make(map[string]map[string]bool)}
+
+// Caches the stages features have been promoted to. Nil until loaded.
+// Never held while loading
+var stageCache = struct {
+	sync.Mutex
+	stages     map[string]stage
+	generation uint64
+}{}
+
+// Looks up a feature by name
+func findFeature(name string) *feature {
+	for _, f := range features {
+		if strings.EqualFold(f.Name, name) {
+			return f
+		}
+	}
+	return nil
+}
+
+// Gets the current rollout stage of the feature, taking promotions into account
+func featureStage(f *feature, i *srv.Instance) stage {
+	stageCache.Lock()
+	stages, generation := stageCache.stages, stageCache.generation
+	stageCache.Unlock()
+	if stages == nil {
+		loaded, err := quoteDB.GetFeatureStages()
+		if err != nil {
+			i.Log.WithField("err", err.Error()).Error("Cannot load feature stages")
+		} else {
+			stages = make(map[string]stage, len(loaded))
+			for name, s := range loaded {
+				stages[name] = stage(s)
+			}
+			stageCache.Lock()
+			if stageCache.generation == generation {
+				stageCache.stages = stages
+			}
+			stageCache.Unlock()
+		}
+	}
+	if s, ok := stages[f.Name]; ok {
+		return s
+	}
+	if f.Stage == "" {
+		return stageStable
+	}
+	return f.Stage
+}
+
+// Returns true if the feature is enabled in the group. Stable features
+// are enabled unless a group has turned them off, beta features are off
+// until a group turns them on, and alpha features only run in alpha groups.
+func featureEnabled(groupID string, f *feature, i *srv.Instance) bool {
+	if f.Required {
+		return true
+	}
+	s := featureStage(f, i)
+	if groups[groupID].IsAlpha {
+		// alpha groups get everything
+		s = stageStable
+	} else if s == stageAlpha {
+		return false
+	}
+
+	flagCache.Lock()
+	flags, ok := flagCache.groups[groupID]
+	generation := flagCache.generation
+	flagCache.Unlock()
+	if !ok {
+		var err error
+		flags, err = quoteDB.GetFeatureFlags(groupID)
+		if err != nil {
+			// don't lock a group out of everything because the db is down
+			i.Log.WithFields(logrus.Fields{
+				"err":   err.Error(),
+				"group": groupID,
+			}).Error("Cannot load feature flags")
+			return true
+		}
+		flagCache.Lock()
+		if flagCache.generation == generation {
+			flagCache.groups[groupID] = flags
+		}
+		flagCache.Unlock()
+	}
+	enabled, ok := flags[f.Name]
+	if !ok {
+		return s == stageStable
+	}
+	return enabled
+}
+
+// Middleware that skips the feature in groups where it's disabled
+func checkFeatureFlag(f *feature, next handler) handler {
+	return func(callback srv.Callback, i *srv.Instance) bool {
+		if !featureEnabled(callback.GroupID, f, i) {
+			return false
+		}
+		return next(callback, i)
+	}
+}
+
+func featuresCommand(callback srv.Callback, i *srv.Instance) (cont bool) {
+	matches := featuresRegex.FindStringSubmatch(callback.Text)
+	if matches == nil {
+		cont = false
+		return
+	}
+	cont = true
+	captureGroups := mapSubexpNames(matches, featuresRegex.SubexpNames())
+	msg := newMessage(callback)
+
+	// no action, list the features of the group
+	if !hasGroup(captureGroups, "Action") && !hasGroup(captureGroups, "Stage") {
+		var lines []string
+		for _, f := range features {
+			if f.Required {
+				continue
+			}
+			state := "on"
+			if !featureEnabled(callback.GroupID, f, i) {
+				state = "off"
+			}
+			if s := featureStage(f, i); s != stageStable {
+				state += fmt.Sprintf(" (%s)", s)
+			}
+			lines = append(lines, fmt.Sprintf("%s: %s", f.Name, state))
+		}
+		sort.Strings(lines)
+		msg.Text = strings.Join(lines, "\n")
+		send(callback.GroupID, i, msg)
+		return
+	}
+
+	if !isAdmin(callback.GroupID, callback.SenderID) {
+		msg.Text = "Only group admins can change features"
+		send(callback.GroupID, i, msg)
+		return
+	}
+
+	if hasGroup(captureGroups, "Stage") {
+		promoteFeature(callback, i, captureGroups["PromoteFeature"], stage(strings.ToLower(captureGroups["Stage"])))
+		return
+	}
+
+	f := findFeature(captureGroups["Feature"])
+	if f == nil {
+		msg.Text = fmt.Sprintf("There's no feature called '%s' (/features)", captureGroups["Feature"])
+		send(callback.GroupID, i, msg)
+		return
+	}
+	if f.Required {
+		msg.Text = fmt.Sprintf("%s can't be turned off", f.Name)
+		send(callback.GroupID, i, msg)
+		return
+	}
+
+	enable := strings.EqualFold(captureGroups["Action"], "enable")
+	err := quoteDB.SetFeatureFlag(callback.GroupID, f.Name, enable)
+	if err != nil {
+		replyError(callback, i, err, "Cannot save feature flag")
+		return
+	}
+
+	// drop the cached flags so they're reloaded on the next callback
+	flagCache.Lock()
+	delete(flagCache.groups, callback.GroupID)
+	flagCache.generation++
+	flagCache.Unlock()
+
+	if enable {
+		msg.Text = fmt.Sprintf("Enabled %s", f.Name)
+	} else {
+		msg.Text = fmt.Sprintf("Disabled %s", f.Name)
+	}
+	send(callback.GroupID, i, msg)
+	return
+}
+
+// Moves a feature to another stage for every group. Only admins of alpha
+// groups can do this since it affects groups they aren't in.
+func promoteFeature(callback srv.Callback, i *srv.Instance, name string, s stage) {
+	msg := newMessage(callback)
+	if !groups[callback.GroupID].IsAlpha {
+		msg.Text = "Features can only be promoted from an alpha group"
+		send(callback.GroupID, i, msg)
+		return
+	}
+	f := findFeature(name)
+	if f == nil || f.Required {
+		msg.Text = fmt.Sprintf("There's no feature called '%s' (/features)", name)
+		send(callback.GroupID, i, msg)
+		return
+	}
+
+	err := quoteDB.SetFeatureStage(f.Name, string(s))
+	if err != nil {
+		replyError(callback, i, err, "Cannot save feature stage")
+		return
+	}
+
+	// reload the stages on the next callback
+	stageCache.Lock()
+	stageCache.stages = nil
+	stageCache.generation++
+	stageCache.Unlock()
+
+	i.Log.WithFields(logrus.Fields{
+		"feature": f.Name,
+		"stage":   s,
+		"by":      callback.SenderID,
+	}).Info("Feature stage changed")
+	msg.Text = fmt.Sprintf("%s is now %s", f.Name, s)
+	send(callback.GroupID, i, msg)
+}
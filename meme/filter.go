@@ -0,0 +1,102 @@
+package meme
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	srv "github.com/ethanzeigler/groupme/botserver"
+	"github.com/ethanzeigler/groupme/gmbots/filter"
+	"github.com/sirupsen/logrus"
+)
+
+// Posted instead of a message the filter blocked
+const blockedText = "[Message removed by the content filter]"
+
+var filterRegex = regexp.MustCompile(`^(?is)/filter\s+test\s+(?P<Text>.+?)\s*$`)
+
+// Checks everything the bot posts. Nil if there's no filter
+var contentFilter filter.Filter
+
+// Sets the filter outgoing messages are checked with
+func SetContentFilter(f filter.Filter) {
+	contentFilter = f
+}
+
+// Runs text through the group's filter policy
+func checkContent(groupID string, text string) filter.Result {
+	return filter.Apply(contentFilter, groups[groupID].Filter, text)
+}
+
+// Gets the text that will actually be posted in place of text
+func outgoingText(groupID string, i *srv.Instance, text string) string {
+	if text == "" {
+		return text
+	}
+	result := checkContent(groupID, text)
+	if len(result.Matches) > 0 && i != nil {
+		i.Log.WithFields(logrus.Fields{
+			"group":   groupID,
+			"matches": len(result.Matches),
+			"blocked": result.Blocked,
+		}).Warning("Outgoing message matched the content filter")
+	}
+	if result.Blocked {
+		return blockedText
+	}
+	return result.Text
+}
+
+// Posts a message after running its text through the group's content filter
+func send(groupID string, i *srv.Instance, msg srv.Message) {
+	if !takeOutbound(groupID) {
+		i.Log.WithField("group", groupID).Warning("Over the outbound budget. Dropped a post")
+		return
+	}
+	msg.Text = outgoingText(groupID, i, msg.Text)
+	outboundPosts.WithLabelValues(groupID).Inc()
+	i.PostMessageAsync(msg, 2)
+}
+
+// Shows admins what the filter would do to some text
+func filterCommand(callback srv.Callback, i *srv.Instance) (cont bool) {
+	matches := filterRegex.FindStringSubmatch(callback.Text)
+	if matches == nil {
+		cont = false
+		return
+	}
+	cont = true
+	text := mapSubexpNames(matches, filterRegex.SubexpNames())["Text"]
+	if contentFilter == nil {
+		reply(callback, i, "There's no content filter set up")
+		return
+	}
+
+	policy := groups[callback.GroupID].Filter
+	severity := policy.Severity
+	if severity == "" {
+		severity = filter.Mask
+	}
+	result := checkContent(callback.GroupID, text)
+	if len(result.Matches) == 0 {
+		reply(callback, i, "Nothing matched")
+		return
+	}
+	var terms []string
+	for _, match := range result.Matches {
+		terms = append(terms, match.Term)
+	}
+	outcome := "it would be let through and logged"
+	if result.Blocked {
+		outcome = "it would be blocked"
+	} else if result.Text != text {
+		outcome = "it would be posted as: " + result.Text
+	}
+	// the reply is filtered too, so only say how many matched
+	i.Log.WithFields(logrus.Fields{
+		"group": callback.GroupID,
+		"terms": strings.Join(terms, ", "),
+	}).Info("Filter test")
+	reply(callback, i, fmt.Sprintf("%d matches, %s (%s)", len(result.Matches), outcome, severity))
+	return
+}
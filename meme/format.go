@@ -0,0 +1,260 @@
+package meme
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	srv "github.com/ethanzeigler/groupme/botserver"
+	"github.com/ethanzeigler/groupme/gmbots/adapter"
+)
+
+const (
+	// how quotes looked before formats could be changed
+	DefaultQuoteFormat = "{{capitalize .Name}} [{{date .Date}}]: {{.Quote}}"
+	// group setting the format is saved under
+	quoteFormatSetting = "quote_format"
+	// longest format a group may save
+	maxFormatLength = 300
+	// longest a formatted quote may be. GroupMe cuts messages off at 1000
+	maxFormattedLength = 1000
+)
+
+var formatRegex = regexp.MustCompile(`^(?is)/quotes\s+format(?:\s+(?P<Format>.+?))?\s*$`)
+
+// What a quote format template is executed with
+type quoteView struct {
+	ID    uint64
+	Name  string
+	Quote string
+	// in the group's time zone
+	Date time.Time
+	// name of whoever recorded the quote. Empty for old quotes
+	Submitter string
+}
+
+// Functions quote formats may use
+var formatFuncs = template.FuncMap{
+	"capitalize": capitalize,
+	"upper":      strings.ToUpper,
+	"lower":      strings.ToLower,
+	// formats a date with a Go layout, like "Mon, Jan 2, 2006" if none is given
+	"date": func(t time.Time, layout ...string) string {
+		if len(layout) > 0 {
+			return t.Format(layout[0])
+		}
+		return t.Format("Mon, Jan 2, 2006")
+	},
+}
+
+// Uppercases the first letter, which may take more than one byte
+func capitalize(s string) string {
+	r, size := utf8.DecodeRuneInString(s)
+	if r == utf8.RuneError {
+		return s
+	}
+	return string(unicode.ToUpper(r)) + s[size:]
+}
+
+// Quote formats are checked against this before they're saved
+var sampleQuote = quoteView{
+	ID:        42,
+	Name:      "ethan",
+	Quote:     "I'll fix it tomorrow",
+	Date:      time.Date(2019, time.May, 1, 17, 30, 0, 0, time.UTC),
+	Submitter: "Someone",
+}
+
+// Parsed quote formats by group. Groups are loaded the first time they
+// format a quote. Never held while loading
+var formatCache = struct {
+	sync.Mutex
+	templates map[string]*template.Template
+	// bumped when a format is dropped, so loads that started before
+	// don't put back the old one
+	generation uint64
+}{templates: make(map[string]*template.Template)}
+
+// Returned when a format makes a quote longer than GroupMe allows
+var errFormatTooLong = errors.New("quotes come out too long with that format")
+
+var defaultTemplate = template.Must(parseQuoteFormat(DefaultQuoteFormat))
+
+func parseQuoteFormat(format string) (*template.Template, error) {
+	return template.New("quote").Funcs(formatFuncs).Option("missingkey=error").Parse(format)
+}
+
+func executeFormat(t *template.Template, view quoteView) (string, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, view); err != nil {
+		return "", err
+	}
+	// a format that's fine on the sample can still blow up a long quote
+	if buf.Len() > maxFormattedLength {
+		return "", errFormatTooLong
+	}
+	return buf.String(), nil
+}
+
+// Cuts the text down to at most n bytes without splitting a character,
+// marking that it was cut
+func truncate(text string, n int) string {
+	if len(text) <= n {
+		return text
+	}
+	const ellipsis = "…"
+	cut := n - len(ellipsis)
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	return text[:cut] + ellipsis
+}
+
+// Checks that a quote format parses and works on a sample quote.
+// The error is meant to be shown to whoever wrote the format.
+func ValidateQuoteFormat(format string) error {
+	if len(format) > maxFormatLength {
+		return fmt.Errorf("formats can be at most %d characters", maxFormatLength)
+	}
+	t, err := parseQuoteFormat(format)
+	if err != nil {
+		return err
+	}
+	text, err := executeFormat(t, sampleQuote)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(text, sampleQuote.Quote) {
+		return errors.New("the format has to include {{.Quote}}")
+	}
+	return nil
+}
+
+// Gets the format the group has saved, falling back to the configured one
+func groupFormat(groupID string) *template.Template {
+	formatCache.Lock()
+	t, ok := formatCache.templates[groupID]
+	generation := formatCache.generation
+	formatCache.Unlock()
+	if ok {
+		return t
+	}
+
+	format, err := quoteDB.GetGroupSetting(groupID, quoteFormatSetting)
+	// if the database is down the configured format will do until next time
+	cache := err == nil || errors.Is(err, adapter.ErrNotFound)
+	if err != nil {
+		format = groups[groupID].QuoteFormat
+	}
+
+	t = defaultTemplate
+	if format != "" {
+		if parsed, err := parseQuoteFormat(format); err == nil {
+			t = parsed
+		}
+	}
+	if cache {
+		formatCache.Lock()
+		if formatCache.generation == generation {
+			formatCache.templates[groupID] = t
+		}
+		formatCache.Unlock()
+	}
+	return t
+}
+
+// Gets what formats and cards are made from, with the date in the group's time zone
+func newQuoteView(quote adapter.Quote, groupID string) quoteView {
+	view := quoteView{Name: *quote.Name, Quote: *quote.Quote}
+	if quote.ID != nil {
+		view.ID = *quote.ID
+	}
+	if quote.Date != nil {
+		view.Date = quote.Date.In(groupLocation(groupID))
+	}
+	if quote.SubmitterName != nil {
+		view.Submitter = *quote.SubmitterName
+	}
+	return view
+}
+
+// Formats a quote the way the group likes
+func formatQuote(quote adapter.Quote, groupID string) string {
+	view := newQuoteView(quote, groupID)
+	text, err := executeFormat(groupFormat(groupID), view)
+	if err != nil || strings.TrimSpace(text) == "" {
+		text, err = executeFormat(defaultTemplate, view)
+	}
+	if err != nil {
+		// the quote alone is too long, so cut it down
+		view.Quote = truncate(view.Quote, maxFormattedLength/2)
+		text, _ = executeFormat(defaultTemplate, view)
+	}
+	return text
+}
+
+// Shows or changes the group's quote format
+func formatCommand(callback srv.Callback, i *srv.Instance) (cont bool) {
+	matches := formatRegex.FindStringSubmatch(callback.Text)
+	if matches == nil {
+		cont = false
+		return
+	}
+	cont = true
+	captureGroups := mapSubexpNames(matches, formatRegex.SubexpNames())
+	format := strings.TrimSpace(captureGroups["Format"])
+
+	if format == "" {
+		reply(callback, i, fmt.Sprintf("Quotes look like:\n%s\n\nChange it with /quotes format <template> using "+
+			"{{.Name}}, {{.Quote}}, {{.Date}}, {{.Submitter}} and {{.ID}}, or go back with /quotes format reset",
+			formatQuote(sampleAdapterQuote(), callback.GroupID)))
+		return
+	}
+
+	if !isAdmin(callback.GroupID, callback.SenderID) {
+		reply(callback, i, "Only group admins can change the quote format")
+		return
+	}
+
+	var err error
+	if strings.EqualFold(format, "reset") {
+		err = quoteDB.DeleteGroupSetting(callback.GroupID, quoteFormatSetting)
+	} else if validateErr := ValidateQuoteFormat(format); validateErr != nil {
+		reply(callback, i, "That format doesn't work: "+validateErr.Error())
+		return
+	} else {
+		err = quoteDB.SetGroupSetting(callback.GroupID, quoteFormatSetting, format)
+	}
+	if err != nil {
+		replyError(callback, i, err, "Cannot save quote format")
+		return
+	}
+
+	// load the new format on the next quote
+	formatCache.Lock()
+	delete(formatCache.templates, callback.GroupID)
+	formatCache.generation++
+	formatCache.Unlock()
+
+	reply(callback, i, "Quotes will look like:\n"+formatQuote(sampleAdapterQuote(), callback.GroupID))
+	return
+}
+
+// The sample quote as it would come from the database
+func sampleAdapterQuote() adapter.Quote {
+	date := sampleQuote.Date
+	return adapter.Quote{
+		ID:            &sampleQuote.ID,
+		Name:          &sampleQuote.Name,
+		Quote:         &sampleQuote.Quote,
+		Date:          &date,
+		SubmitterName: &sampleQuote.Submitter,
+	}
+}
@@ -0,0 +1,124 @@
+package meme
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateQuoteFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		// part of the error, empty if the format is valid
+		wantErr string
+	}{
+		{"default", DefaultQuoteFormat, ""},
+		{"quote only", "{{.Quote}}", ""},
+		{"all fields", "#{{.ID}} {{upper .Name}} on {{date .Date \"2006-01-02\"}} by {{.Submitter}}: {{.Quote}}", ""},
+		{"lower", "{{lower .Name}}: {{.Quote}}", ""},
+		{"missing quote", "{{.Name}} said something", "has to include"},
+		{"unknown field", "{{.Speaker}}: {{.Quote}}", "Speaker"},
+		{"unknown function", "{{shout .Name}}: {{.Quote}}", "shout"},
+		{"parse error", "{{.Quote", "unclosed action"},
+		{"wrong argument", "{{date .Name}}: {{.Quote}}", "wrong type"},
+		{"too long", strings.Repeat("x", maxFormatLength) + "{{.Quote}}", "at most"},
+		{"output too long", `{{$q := printf "%s%s%s%s%s%s%s%s%s%s" .Quote .Quote .Quote .Quote .Quote .Quote .Quote .Quote .Quote .Quote}}` +
+			"{{$q}}{{$q}}{{$q}}{{$q}}{{$q}}{{$q}}", "too long"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateQuoteFormat(tc.format)
+			if tc.wantErr == "" && err != nil {
+				t.Errorf("ValidateQuoteFormat(%q) = %v, want nil", tc.format, err)
+			} else if tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+				t.Errorf("ValidateQuoteFormat(%q) = %v, want an error about %q", tc.format, err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestExecuteFormat(t *testing.T) {
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{DefaultQuoteFormat, "Ethan [Wed, May 1, 2019]: I'll fix it tomorrow"},
+		{"{{upper .Name}}: {{.Quote}}", "ETHAN: I'll fix it tomorrow"},
+		{"#{{.ID}} {{.Quote}} ({{.Submitter}}, {{date .Date \"Jan 2006\"}})", "#42 I'll fix it tomorrow (Someone, May 2019)"},
+	}
+	for _, tc := range tests {
+		parsed, err := parseQuoteFormat(tc.format)
+		if err != nil {
+			t.Fatalf("parseQuoteFormat(%q): %v", tc.format, err)
+		}
+		got, err := executeFormat(parsed, sampleQuote)
+		if err != nil {
+			t.Fatalf("executeFormat(%q): %v", tc.format, err)
+		}
+		if got != tc.want {
+			t.Errorf("executeFormat(%q) = %q, want %q", tc.format, got, tc.want)
+		}
+	}
+}
+
+func TestFormatQuoteLongQuotes(t *testing.T) {
+	// fine on the sample, but repeats the quote three times
+	tripled, err := parseQuoteFormat("{{.Quote}} {{.Quote}} {{.Quote}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	formatCache.Lock()
+	formatCache.templates["format test"] = tripled
+	formatCache.Unlock()
+	defer func() {
+		formatCache.Lock()
+		delete(formatCache.templates, "format test")
+		formatCache.Unlock()
+	}()
+
+	quote := sampleAdapterQuote()
+	tests := []struct {
+		name   string
+		length int
+		// whether the group's format is used
+		formatted bool
+	}{
+		{"short", 20, true},
+		{"too long for the format", 400, false},
+		{"too long for anything", 2000, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			text := strings.Repeat("é", tc.length/2)
+			quote.Quote = &text
+			got := formatQuote(quote, "format test")
+			if len(got) > maxFormattedLength {
+				t.Errorf("formatted quote is %d bytes, want at most %d", len(got), maxFormattedLength)
+			}
+			if formatted := strings.Count(got, "éé") > tc.length/2; formatted != tc.formatted {
+				t.Errorf("group format used is %v, want %v: %q", formatted, tc.formatted, got)
+			}
+			if !strings.HasPrefix(got, "Ethan [") && !tc.formatted {
+				t.Errorf("didn't fall back to the default format: %q", got)
+			}
+		})
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		text string
+		n    int
+		want string
+	}{
+		{"short", 10, "short"},
+		{"exactly10!", 10, "exactly10!"},
+		{"a bit too long", 10, "a bit t…"},
+		{"ééééé", 8, "éé…"},
+	}
+	for _, tc := range tests {
+		if got := truncate(tc.text, tc.n); got != tc.want {
+			t.Errorf("truncate(%q, %d) = %q, want %q", tc.text, tc.n, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package meme
+
+import (
+	"math/rand"
+	"regexp"
+	"strings"
+	"sync"
+
+	srv "github.com/ethanzeigler/groupme/botserver"
+	"github.com/ethanzeigler/groupme/gmbots/adapter"
+)
+
+const (
+	// words of context used to pick the next word
+	markovOrder = 2
+	// longest quote that will be generated, in words
+	markovMaxWords = 40
+	// most quotes a model is trained on
+	markovCorpusLimit = 1000
+	// tries at generating something that isn't a copy of a real quote
+	markovAttempts = 10
+)
+
+var generateRegex = regexp.MustCompile(`^(?i)/quotes\s+generate\s*$`)
+
+// Word chain trained on a set of quotes
+type markovModel struct {
+	// possible next words for each run of markovOrder words. Runs at the
+	// start of a quote are padded with empty words
+	chain map[string][]string
+	// the training quotes, so copies can be thrown out
+	corpus map[string]bool
+}
+
+// Trained models by group and name. Names keep their case since quotes
+// are looked up by name case-sensitively. The group-wide model is stored
+// under an empty name. Never held while training
+var markovCache = struct {
+	sync.Mutex
+	models map[string]*markovModel
+	// bumped by group when models are dropped, so models trained on
+	// quotes that were just removed aren't put back
+	generations map[string]uint64
+}{models: make(map[string]*markovModel), generations: make(map[string]uint64)}
+
+func markovKey(groupID string, name string) string {
+	return groupID + "/" + name
+}
+
+// Drops the cached models affected by a change to someone's quotes. A
+// LIKE pattern can match names written differently, so every model of a
+// name spelled the same regardless of case goes too
+func invalidateMarkov(groupID string, name string) {
+	markovCache.Lock()
+	defer markovCache.Unlock()
+	markovCache.generations[groupID]++
+	prefix := markovKey(groupID, "")
+	for key := range markovCache.models {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if cached := key[len(prefix):]; cached == "" || strings.EqualFold(cached, name) {
+			delete(markovCache.models, key)
+		}
+	}
+}
+
+func trainMarkov(quotes []adapter.Quote) *markovModel {
+	model := &markovModel{
+		chain:  make(map[string][]string),
+		corpus: make(map[string]bool),
+	}
+	for _, quote := range quotes {
+		words := strings.Fields(*quote.Quote)
+		if len(words) == 0 {
+			continue
+		}
+		model.corpus[strings.Join(words, " ")] = true
+		padded := make([]string, markovOrder, markovOrder+len(words))
+		padded = append(padded, words...)
+		for n := markovOrder; n <= len(padded); n++ {
+			key := strings.Join(padded[n-markovOrder:n], " ")
+			next := ""
+			if n < len(padded) {
+				next = padded[n]
+			}
+			// an empty next word ends the quote
+			model.chain[key] = append(model.chain[key], next)
+		}
+	}
+	return model
+}
+
+func (m *markovModel) generate() string {
+	var words []string
+	for attempt := 0; attempt < markovAttempts; attempt++ {
+		state := make([]string, markovOrder)
+		words = words[:0]
+		for len(words) < markovMaxWords {
+			options := m.chain[strings.Join(state, " ")]
+			if len(options) == 0 {
+				break
+			}
+			next := options[rand.Intn(len(options))]
+			if next == "" {
+				break
+			}
+			words = append(words, next)
+			state = append(state[1:], next)
+		}
+		if !m.corpus[strings.Join(words, " ")] {
+			break
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// Caches a trained model unless the group's models were dropped since
+// the generation was taken
+func storeMarkov(groupID string, key string, generation uint64, model *markovModel) {
+	markovCache.Lock()
+	defer markovCache.Unlock()
+	if markovCache.generations[groupID] == generation {
+		markovCache.models[key] = model
+	}
+}
+
+// Makes up a quote in the style of the named person, or of the whole
+// group if name is empty. Models are trained the first time they're needed.
+func generateQuote(name string, callback srv.Callback) (string, error) {
+	key := markovKey(callback.GroupID, name)
+	markovCache.Lock()
+	model, ok := markovCache.models[key]
+	generation := markovCache.generations[callback.GroupID]
+	markovCache.Unlock()
+
+	if !ok {
+		pattern := name
+		if pattern == "" {
+			pattern = "%"
+		}
+		quotes, err := quoteDB.GetQuotes(pattern, callback, markovCorpusLimit, adapter.QuoteIDSort)
+		if err != nil {
+			return "", err
+		}
+		model = trainMarkov(quotes)
+		storeMarkov(callback.GroupID, key, generation, model)
+	}
+	return model.generate(), nil
+}
+
+func generateCommand(callback srv.Callback, i *srv.Instance) (cont bool) {
+	if !generateRegex.MatchString(callback.Text) {
+		cont = false
+		return
+	}
+	cont = true
+	text, err := generateQuote("", callback)
+	if err != nil {
+		replyError(callback, i, err, "Cannot generate quote")
+		return
+	}
+	reply(callback, i, "[Generated] "+text)
+	return
+}
@@ -0,0 +1,97 @@
+package meme
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestInvalidateMarkov(t *testing.T) {
+	tests := []struct {
+		name    string
+		changed string
+		want    []string
+	}{
+		{"same case", "Ethan", []string{"1/bob", "2/", "2/Ethan"}},
+		{"other case", "ETHAN", []string{"1/bob", "2/", "2/Ethan"}},
+		{"someone else", "bob", []string{"1/Ethan", "1/ethan", "2/", "2/Ethan"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			markovCache.models = make(map[string]*markovModel)
+			for _, key := range []string{"1/", "1/Ethan", "1/ethan", "1/bob", "2/", "2/Ethan"} {
+				markovCache.models[key] = &markovModel{}
+			}
+			invalidateMarkov("1", tc.changed)
+
+			var left []string
+			for key := range markovCache.models {
+				left = append(left, key)
+			}
+			sort.Strings(left)
+			if len(left) != len(tc.want) {
+				t.Fatalf("models left are %v, want %v", left, tc.want)
+			}
+			for n := range left {
+				if left[n] != tc.want[n] {
+					t.Fatalf("models left are %v, want %v", left, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestQuoteCommands(t *testing.T) {
+	tests := []struct {
+		text  string
+		group string
+		value string
+	}{
+		{"/ethanism", "Name", "ethan"},
+		{"/ethanism record hello there", "Argument", "hello there"},
+		{"/ethanism generate", "Action", "generate"},
+		{"/ethanism CARD", "Action", "CARD"},
+		{"/ethanism generate please", "ImproperData", " generate please"},
+		{"/ethanism delete", "Subcommand", "delete"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.text, func(t *testing.T) {
+			matches := quoteRegex.FindStringSubmatch(tc.text)
+			if matches == nil {
+				t.Fatal("didn't match")
+			}
+			if got := mapSubexpNames(matches, quoteRegex.SubexpNames())[tc.group]; got != tc.value {
+				t.Errorf("%s is %q, want %q", tc.group, got, tc.value)
+			}
+		})
+	}
+}
+
+func TestStoreMarkovAfterInvalidate(t *testing.T) {
+	tests := []struct {
+		name string
+		// change to someone's quotes while the model was training, if any
+		changedGroup, changedName string
+		stored                    bool
+	}{
+		{"nothing changed", "", "", true},
+		{"quotes removed", "1", "Ethan", false},
+		{"someone else's quotes removed", "1", "bob", false},
+		{"other group", "2", "Ethan", true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			markovCache.models = make(map[string]*markovModel)
+			key := markovKey("1", "Ethan")
+			markovCache.Lock()
+			generation := markovCache.generations["1"]
+			markovCache.Unlock()
+			if tc.changedGroup != "" {
+				invalidateMarkov(tc.changedGroup, tc.changedName)
+			}
+			storeMarkov("1", key, generation, &markovModel{})
+			if _, stored := markovCache.models[key]; stored != tc.stored {
+				t.Errorf("stored is %v, want %v", stored, tc.stored)
+			}
+		})
+	}
+}
@@ -1,58 +1,105 @@
 package meme
 
 import (
+	"errors"
 	"fmt"
 	"github.com/ethanzeigler/groupme/gmbots/adapter"
 	"github.com/sirupsen/logrus"
-	"math/rand"
 	"regexp"
-	"strconv"
 	"strings"
+	"time"
 
 	srv "github.com/ethanzeigler/groupme/botserver"
 )
 
 var idMap map[string]string
 var quoteRegex *regexp.Regexp
+var roastedRegex = regexp.MustCompile(`^(?i)/roasted$`)
+var pikaRegex = regexp.MustCompile(`^(?i)(.*\s)?/pika$`)
+var justRightRegex = regexp.MustCompile(`^(?i)(.*\s)?/just\sright$`)
+var backdateRegex = regexp.MustCompile(`^--on\s+(\S+)\s+(.+)$`)
+
+// Commands of other hooks that quoteRegex would take for a quote
+var quotesCommandRegex = regexp.MustCompile(`^(?i)/quotes\s`)
+
+// Connection to the quote database
+var quoteDB *adapter.MemeDB
 
 func init() {
-	quoteRegex = regexp.MustCompile(`^(?i)/(?P<Name>.+)ism(?:\s+(?P<Subcommand>record|delete)\s*(?P<Argument>.+)?|(?P<ImproperData>.*))?\s*$`)
+	quoteRegex = regexp.MustCompile(`^(?i)/(?P<Name>.+)ism(?:\s+(?P<Subcommand>record|delete)\s*(?P<Argument>.+)?|\s+(?P<Action>generate|card)|(?P<ImproperData>.*))?\s*$`)
 }
 
 // Create the meme machine channel
-func MakeMemeChannel(db *adapter.MemeDB) (channel srv.Channel) {
+func MakeMemeChannel(db *adapter.MemeDB, groupConfigs []Group) (channel srv.Channel) {
 	c := &channel
 	c.Name = "Meme Machine"
+	quoteDB = db
+	quoteDB.AddChangeListener(invalidateMarkov)
 	// Stores the group IDs this channel will listen to
-	c.GroupIDs = []string{"01234", "46818924"}
-	idMap = map[string]string{
-		// TSCE
-		"30154628": "6df3e3f76b0bfd181f4c41f343",
-		// testing
-		"46818924": "92c14c082f6e0f860072542b7c",
+	groups = make(map[string]Group, len(groupConfigs))
+	idMap = make(map[string]string, len(groupConfigs))
+	for _, group := range groupConfigs {
+		c.GroupIDs = append(c.GroupIDs, group.GroupID)
+		groups[group.GroupID] = group
+		idMap[group.GroupID] = group.BotID
 	}
 
-	// Create Hooks
-
-	// Create hook responsible for the quote system, managing the
-	// quote database and other functions
-	quoteHook := srv.BasicHook{DebugName: "Quote System", Handler: quoteRequest}
-	c.AddHook(&quoteHook)
-
-	roastedHook := srv.BasicHook{DebugName: "Roasted", Handler: roasted}
-	c.AddHook(&roastedHook)
-
-	connectFourHook := srv.BasicHook{DebugName: "Connect 4", Handler: connectFour}
-	c.AddHook(&connectFourHook)
-
-	helpHook := srv.BasicHook{DebugName: "Help", Handler: helpCommand}
-	c.AddHook(&helpHook)
-
-	pikachuHook := srv.BasicHook{DebugName: "Pikachu", Handler: pikachu}
-	c.AddHook(&pikachuHook)
-
-	justRightHook := srv.BasicHook{DebugName: "Just right", Handler: justRight}
-	c.AddHook(&justRightHook)
+	// Create Hooks. A feature still being tried out can be registered as
+	// alpha or beta and promoted with /features promote once it's ready
+	features = []*feature{
+		// trivia goes before the quote system so "/guess <name>ism" counts as a guess
+		{Name: "trivia", Help: "/quotes game [stop|scores] - Who said it? Answer with /guess <name>",
+			Trigger: triviaRegex, Hook: srv.BasicHook{DebugName: "Quote Trivia", Handler: triviaCommand}},
+		{Name: "format", Help: "/quotes format [<template>|reset] - Change how quotes look",
+			Trigger: formatRegex, Hook: srv.BasicHook{DebugName: "Quote Format", Handler: formatCommand}},
+		{Name: "moderation", Required: true, AdminOnly: true,
+			Help:    "/quotes pending|approve <id>|reject <id> [reason] - Review quotes in moderated groups (admins)",
+			Trigger: moderationRegex, Hook: srv.BasicHook{DebugName: "Moderation", Handler: moderationCommand}},
+		{Name: "privacy", Required: true,
+			Help: "/quotes optout|optin <name> - Stop or allow quotes of you\n/quotes forget me [and my submissions] - Delete your quotes\n" +
+				"/quotes claims|verify <name>|unclaim <name> - Review names claimed in opt outs (admins)",
+			Trigger: privacyRegex, Hook: srv.BasicHook{DebugName: "Privacy", Handler: privacyCommand}},
+		{Name: "filter", AdminOnly: true, Help: "/filter test <text> - Check what the content filter does (admins)",
+			Trigger: filterRegex, Hook: srv.BasicHook{DebugName: "Filter", Handler: filterCommand}},
+		{Name: "dashboard", AdminOnly: true, Help: "/quotes dashboard - Get a link to browse and edit quotes on the web (admins)",
+			Trigger: dashboardRegex, Hook: srv.BasicHook{DebugName: "Dashboard", Handler: dashboardCommand}},
+		{Name: "generate", Help: "/quotes generate - Make up a quote from everyone's quotes (/<name>ism generate for one person)",
+			Trigger: generateRegex, Hook: srv.BasicHook{DebugName: "Quote Generator", Handler: generateCommand}},
+		{Name: "quote", Help: "/quote [<id> [card|+1|-1]] - Random quote from anyone in the group, or the quote with that ID",
+			Trigger: randomQuoteRegex, Hook: srv.BasicHook{DebugName: "Random Quote", Handler: randomQuote}},
+		{Name: "top", Help: "/quotes top - Highest voted quotes",
+			Trigger: topQuotesRegex, Hook: srv.BasicHook{DebugName: "Top Quotes", Handler: topQuotesCommand}},
+		// Create hook responsible for the quote system, managing the
+		// quote database and other functions. Goes after every /quotes
+		// command since "/quotes optout /mikeism" also looks like a quote
+		{Name: "quotes", Help: "/<name>ism [record [--on <yyyy-mm-dd>] <message>|delete|generate|card] - Group member quotes and adding new ones",
+			Trigger: quoteRegex, Hook: srv.BasicHook{DebugName: "Quote System", Handler: quoteRequest}},
+		{Name: "roasted", Help: "/roasted - Roasted by the group meme",
+			Trigger: roastedRegex, Hook: srv.BasicHook{DebugName: "Roasted", Handler: roasted}},
+		{Name: "c4", Help: "/c4 [meme] [1-9] - Connect 4 memes",
+			Trigger: c4MemeRegex, Hook: srv.BasicHook{DebugName: "Connect 4 Memes", Handler: c4Meme}},
+		// separate from the memes so their cooldown doesn't hold up moves
+		{Name: "c4game", Help: "/c4 challenge @<name>|drop <1-7>|board|forfeit|leaderboard - Play Connect 4",
+			Trigger: c4Regex, Hook: srv.BasicHook{DebugName: "Connect 4", Handler: connectFour}},
+		{Name: "help", Required: true,
+			Hook: srv.BasicHook{DebugName: "Help", Handler: helpCommand}},
+		{Name: "features", Required: true, Help: "/features [enable|disable <feature>] [promote <feature> <stage>] - Turn commands on and off",
+			Hook: srv.BasicHook{DebugName: "Features", Handler: featuresCommand}},
+		{Name: "caption", Help: "/caption <template> \"top\" \"bottom\" - Make a meme (/caption list for templates)",
+			Trigger: captionRegex, Hook: srv.BasicHook{DebugName: "Caption", Handler: captionCommand}},
+		{Name: "pika", Help: "/pika - Pikachu surprised meme",
+			Trigger: pikaRegex, Hook: srv.BasicHook{DebugName: "Pikachu", Handler: pikachu}},
+		{Name: "justright", Help: "/just right - Hercules meme",
+			Trigger: justRightRegex, Hook: srv.BasicHook{DebugName: "Just right", Handler: justRight}},
+	}
+	// sees every callback, including the bot's own posts, so it goes first,
+	// skips sender filtering and counts what comes in
+	quoteMessages := &feature{Name: "quote messages", Required: true,
+		Hook: srv.BasicHook{DebugName: "Quote Messages", Handler: quoteMessageHook}}
+	c.AddHook(quoteMessages.hook([]middleware{recoverPanic, countCallbacks}))
+	for _, f := range features {
+		c.AddHook(f.hook(channelMiddleware))
+	}
 	return
 }
 
@@ -60,8 +107,8 @@ func quoteRequest(callback srv.Callback, i *srv.Instance) (cont bool) {
 	// check if responsible
 	matches := quoteRegex.FindStringSubmatch(callback.Text)
 
-	// no match?
-	if matches == nil {
+	// no match? "/quotes optout /mikeism" belongs to the privacy hook
+	if matches == nil || quotesCommandRegex.MatchString(callback.Text) {
 		cont = false
 		return
 	}
@@ -77,139 +124,165 @@ func quoteRequest(callback srv.Callback, i *srv.Instance) (cont bool) {
 
 	// This will be handed successfully, so we can take ownership
 	cont = true
-	msg := srv.Message{BotID: idMap[callback.GroupID]}
+	msg := newMessage(callback)
 
 	// is the command used correctly?
-	if hasGroup(captureGroups, "ImproperData") ||
-		(hasGroup(captureGroups, "Subcommand") && !hasGroup(captureGroups, "Argument")) {
+	action := quoteActionOf(captureGroups)
+	if action == quoteInvalid {
 		msg.Text = "Hmm. I don't understand this extra information. Did you want a subcommand? (/commands)"
-		i.PostMessageAsync(msg, 2)
+		send(callback.GroupID, i, msg)
 		return
 	}
 
 	// Get quotee name
 	selectedName := strings.TrimSpace(captureGroups["Name"])
 
-	// subcommand and argument?
-	if hasGroup(captureGroups, "Subcommand") && hasGroup(captureGroups, "Argument") {
-		subcommand := strings.TrimSpace(captureGroups["Subcommand"])
+	switch action {
+	case quoteRecord:
 		argument := strings.TrimSpace(captureGroups["Argument"])
+		i.Log.Debug("Recording Quote " + selectedName)
+
+		// quotes from before the bot can be backdated with --on <date>
+		date := time.Now()
+		if matches := backdateRegex.FindStringSubmatch(argument); matches != nil {
+			var err error
+			date, err = time.ParseInLocation("2006-01-02", matches[1], groupLocation(callback.GroupID))
+			if err != nil || date.After(time.Now()) {
+				msg.Text = "Use a date in the past like --on 2019-05-01"
+				send(callback.GroupID, i, msg)
+				return
+			}
+			argument = strings.TrimSpace(matches[2])
+		}
 
-		// =================================
-		// We need to check for a sub command
-		// Wow this code is getting big and messy
-		// =================================
-
-		// record subcommand
-		if strings.EqualFold(subcommand, "record") {
-			i.Log.("Recording Quote " + selectedName)
-
-			// Write quote to the psql db
-			err := adapter.WriteUserQuote(selectedName, argument, callback)
-			if err == nil {
-				i.LogDebug("Success!")
-				msg.Text = "👍"
-				i.PostMessageAsync(msg, 2)
-			} else {
-				i.LogError("Couldn't record: " + err.Error())
-				msg.Text = "[Error: Reported to developer] " + err.Error()
-				i.PostMessageAsync(msg, 2)
+		// Write quote to the psql db. Moderated groups hold it for approval
+		write := quoteDB.WriteUserQuoteOn
+		pending := needsApproval(callback)
+		if pending {
+			write = quoteDB.WritePendingQuoteOn
+		}
+		err := write(selectedName, argument, date, callback)
+		if err == nil && pending {
+			msg.Text = "Sent to the moderators for approval"
+			send(callback.GroupID, i, msg)
+		} else if err == nil {
+			i.Log.Debug("Success!")
+			if result := checkContent(callback.GroupID, argument); len(result.Matches) > 0 {
+				i.Log.WithFields(logrus.Fields{
+					"group":  callback.GroupID,
+					"sender": callback.SenderID,
+				}).Warning("Recorded quote matched the content filter")
 			}
+			msg.Text = "👍"
+			send(callback.GroupID, i, msg)
+		} else if errors.Is(err, adapter.ErrQueued) {
+			i.Log.Warning("Database unavailable, quote queued")
+			msg.Text = "Saved, will sync later"
+			send(callback.GroupID, i, msg)
+		} else {
+			replyError(callback, i, err, "Couldn't record")
 		}
 
-		// delete subcommand
-	} else if hasGroup(captureGroups, "Subcommand") {
-		subcommand := strings.TrimSpace(captureGroups["Subcommand"])
-
-		if strings.EqualFold(subcommand, "delete") {
-			srv.LogDebug("Deleting quote")
-			quote, err := adapter.GetQuotes(selectedName, callback, 1, adapter.QuoteIDSort)
-			if err != nil {
-				if err.Error() == "no quotes found" {
-					srv.LogDebug("Quote doesn't exist")
-					msg.Text = "Can't delete quote: " + err.Error()
-					srv.PostMessageAsync(msg, 2)
+	case quoteDelete:
+		// deletes the person's newest quote
+		i.Log.Debug("Deleting quote")
+		quote, err := quoteDB.GetQuotes(selectedName, callback, 1, adapter.QuoteIDSort)
+		if err != nil {
+			replyError(callback, i, err, "Couldn't find quote to delete")
+		} else {
+			// check that it's sent by the person who originally submitted the quote
+			if *quote[0].SubmitterID == callback.SenderID {
+				_, err := quoteDB.DeleteQuote(quote[0])
+				if err != nil {
+					replyError(callback, i, err, "Couldn't delete quote")
 				} else {
-					srv.LogDebug("Failed to connect to db")
-					msg.Text = "[Error: Reported to developer] " + err.Error()
-					srv.PostMessageAsync(msg, 2)
+					msg.Text = fmt.Sprintf("Deleted '%s'", *quote[0].Quote)
+					send(callback.GroupID, i, msg)
 				}
 			} else {
-				// check that it's sent by the person who originally submitted the quote
-				if *quote[0].SubmitterID == callback.SenderID {
-					_, err := adapter.DeleteQuote(quote[0])
-					if err != nil {
-						msg.Text = "Couldn't delete that quote: " + err.Error()
-						srv.PostMessageAsync(msg, 2)
-					} else {
-						msg.Text = fmt.Sprintf("Deleted '%s'", *quote[0].Quote)
-						srv.PostMessageAsync(msg, 2)
-					}
-				} else {
-					// someone else is trying to delete the quote
-					msg.Text = "Only the person who wrote the quote can delete it"
-					srv.PostMessageAsync(msg, 2)
-				}
+				// someone else is trying to delete the quote
+				msg.Text = "Only the person who wrote the quote can delete it"
+				send(callback.GroupID, i, msg)
 			}
-		} else {
-			srv.LogWarning("Bad input interpreted as a subcommand")
-			msg.Text = "Internal error. Misinterpreted the message."
-			srv.PostMessageAsync(msg, 2)
 		}
-	} else {
-		// there isn't a subcommand. Get a quote from the person
 
-		quote, err := adapter.GetUserQuote(selectedName, callback)
+	case quoteGenerate:
+		text, err := generateQuote(selectedName, callback)
+		if err != nil {
+			replyError(callback, i, err, "Cannot generate quote")
+			return
+		}
+		msg.Text = fmt.Sprintf("[Generated] %s: %s", capitalize(selectedName), text)
+		send(callback.GroupID, i, msg)
+
+	case quoteCard:
+		quote, err := quoteDB.GetUserQuote(selectedName, callback)
+		if err != nil {
+			replyNotFound(callback, i, err, selectedName)
+			return
+		}
+		quote.Name = &selectedName
+		postCard(callback, i, quote)
+
+	default:
+		// there isn't a subcommand. Get a quote from the person
+		quote, err := pickQuote(selectedName, callback)
 		if err != nil {
-			srv.Log.WithFields(logrus.Fields{
-				"err":   err.Error(),
-				"name":  selectedName,
-				"group": callback.GroupID,
-			}).Error("Cannot query database")
-			msg.Text = fmt.Sprint("Cannot get quote: " + err.Error())
-			srv.PostMessageAsync(msg, 2)
+			replyNotFound(callback, i, err, selectedName)
 			return
 		}
-		// write first letter of name
-		msg.Text += strings.ToUpper(string(selectedName[0]))
-		// write rest of name and quote
-		date := quote.Date.Format("Mon, Jan 2, 1970")
-		msg.Text += fmt.Sprintf("%s [%s]: %s", selectedName[1:], date, *quote.Quote)
-		s.PostMessageAsync(msg, 2)
+		// show the name the way it was asked for
+		quote.Name = &selectedName
+		replyQuote(callback, i, quote)
 	}
 	return
 }
 
-func roasted(callback srv.Callback, i *srv.Instance) (cont bool) {
-	if strings.EqualFold(callback.Text, "/roasted") {
-		msg := srv.Message{BotID: idMap[callback.GroupID]}
-		msg.Picture = "https://i.groupme.com/750x703.jpeg.4bc7c92a3a23460da1dff0c2490de22f"
-		s.PostMessageAsync(msg, 2)
-		cont = true
-	} else {
-		cont = false
+// What a quote request asks the quote system to do
+type quoteAction int
+
+const (
+	// extra information that isn't a subcommand
+	quoteInvalid quoteAction = iota
+	// get a quote of the person
+	quoteShow
+	quoteRecord
+	quoteDelete
+	quoteGenerate
+	quoteCard
+)
+
+// Works out which subcommand a quote request is for from its captures.
+// record needs a message and the others don't take one
+func quoteActionOf(captureGroups map[string]string) quoteAction {
+	if hasGroup(captureGroups, "ImproperData") {
+		return quoteInvalid
 	}
-	return
+	subcommand := strings.ToLower(strings.TrimSpace(captureGroups["Subcommand"]))
+	argument := hasGroup(captureGroups, "Argument")
+	switch {
+	case subcommand == "record" && argument:
+		return quoteRecord
+	case subcommand == "delete" && !argument:
+		return quoteDelete
+	case subcommand != "":
+		return quoteInvalid
+	}
+	switch strings.ToLower(strings.TrimSpace(captureGroups["Action"])) {
+	case "generate":
+		return quoteGenerate
+	case "card":
+		return quoteCard
+	}
+	return quoteShow
 }
 
-func connectFour(callback srv.Callback, i *srv.Instance) (cont bool) {
-	matched, _ := regexp.Match("^(?i)(.*\\s)?/c4(\\s[1-9])?$", []byte(callback.Text))
-	if matched {
+func roasted(callback srv.Callback, i *srv.Instance) (cont bool) {
+	if roastedRegex.MatchString(callback.Text) {
+		msg := newMessage(callback)
+		msg.Picture = "https://i.groupme.com/750x703.jpeg.4bc7c92a3a23460da1dff0c2490de22f"
+		send(callback.GroupID, i, msg)
 		cont = true
-		msg := srv.Message{BotID: idMap[callback.GroupID]}
-		args := strings.Split(strings.TrimSpace(callback.Text), " ")
-		if len(args) > 1 {
-			count, _ := strconv.Atoi(args[1])
-			defer func(n int) {
-				for i := 0; i < count; i++ {
-					msg.Picture = c4Images[rand.Intn(len(c4Images))]
-					srv.PostMessageSync(msg, 1)
-				}
-			}(count)
-		} else {
-			msg.Picture = c4Images[rand.Intn(len(c4Images))]
-			srv.PostMessageAsync(msg, 2)
-		}
 	} else {
 		cont = false
 	}
@@ -217,25 +290,25 @@ func connectFour(callback srv.Callback, i *srv.Instance) (cont bool) {
 }
 
 func pikachu(callback srv.Callback, i *srv.Instance) (cont bool) {
-	matches, _ := regexp.Match("^(?i)(.*\\s)?/pika$", []byte(callback.Text))
+	matches := pikaRegex.MatchString(callback.Text)
 	if matches {
 		cont = true
-		msg := srv.Message{BotID: idMap[callback.GroupID]}
+		msg := newMessage(callback)
 		msg.Picture = "https://i.groupme.com/1354x784.png.75b2bbb3210c463094551c5dbf396672"
-		srv.PostMessageAsync(msg, 2)
+		send(callback.GroupID, i, msg)
 	} else {
 		cont = false
 	}
 	return
 }
 
-func justRight(callback srv.Callback, s *srv.Instance) (cont bool) {
-	matches, _ := regexp.Match("^(?i)(.*\\s)?/just\\sright$", []byte(callback.Text))
+func justRight(callback srv.Callback, i *srv.Instance) (cont bool) {
+	matches := justRightRegex.MatchString(callback.Text)
 	if matches {
 		cont = true
-		msg := srv.Message{BotID: idMap[callback.GroupID]}
+		msg := newMessage(callback)
 		msg.Picture = "https://i.groupme.com/480x480.jpeg.f880c37db898434fbe7def6504225c7d"
-		srv.PostMessageAsync(msg, 2)
+		send(callback.GroupID, i, msg)
 	} else {
 		cont = false
 	}
@@ -246,13 +319,14 @@ func helpCommand(callback srv.Callback, i *srv.Instance) (cont bool) {
 	matches, _ := regexp.Match("^(?i)/help$", []byte(callback.Text))
 	if matches {
 		cont = true
-		msg := srv.Message{BotID: idMap[callback.GroupID]}
-		msg.Text = "/<name>ism [record <message>] - Group member quotes and adding new ones\n" +
-			"/just right - Hercules meme\n" +
-			"/c4 [1-9] - Connect 4 memes\n" +
-			"/pika - Pikachu surprised meme\n" +
-			"/roasted - Roasted by the group meme\n"
-		srv.PostMessageAsync(msg, 2)
+		msg := newMessage(callback)
+		// only list the commands enabled in this group
+		for _, f := range features {
+			if f.Help != "" && featureEnabled(callback.GroupID, f, i) {
+				msg.Text += f.Help + "\n"
+			}
+		}
+		send(callback.GroupID, i, msg)
 	} else {
 		cont = false
 	}
@@ -0,0 +1,84 @@
+package meme
+
+import (
+	"testing"
+
+	srv "github.com/ethanzeigler/groupme/botserver"
+	"github.com/ethanzeigler/groupme/gmbots/adapter"
+)
+
+// Returns the first feature, in the order the channel registers their
+// hooks, that recognizes the text
+func claimingFeature(text string) *feature {
+	for _, f := range features {
+		if f.Trigger != nil && f.Trigger.MatchString(text) {
+			return f
+		}
+	}
+	return nil
+}
+
+func TestQuotesCommandsReachTheirHooks(t *testing.T) {
+	MakeMemeChannel(&adapter.MemeDB{}, nil)
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"/quotes optout /mikeism", "privacy"},
+		{"/quotes optin mikeism", "privacy"},
+		{"/quotes optout mike", "privacy"},
+		{"/quotes verify mikeism", "privacy"},
+		{"/quotes unclaim /mikeism", "privacy"},
+		{"/quotes forget me", "privacy"},
+		{"/quotes format {{.Name}}ism: {{.Quote}}", "format"},
+		{"/quotes approve 12", "moderation"},
+		{"/quotes game", "trivia"},
+		{"/quotes generate", "generate"},
+		{"/quotes top", "top"},
+		{"/quotes dashboard", "dashboard"},
+		{"/mikeism", "quotes"},
+		{"/mikeism record quotes are fun", "quotes"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.text, func(t *testing.T) {
+			f := claimingFeature(tc.text)
+			if f == nil || f.Name != tc.want {
+				t.Fatalf("claimed by %+v, want %s", f, tc.want)
+			}
+			// the quote handler has to pass on them too, whatever the order
+			if tc.want != "quotes" && quoteRequest(srv.Callback{Text: tc.text}, nil) {
+				t.Error("quote hook took the message")
+			}
+		})
+	}
+}
+
+func TestQuoteActions(t *testing.T) {
+	tests := []struct {
+		text string
+		want quoteAction
+	}{
+		{"/ethanism", quoteShow},
+		{"/ethanism record hello there", quoteRecord},
+		{"/ethanism RECORD --on 2019-05-01 hello", quoteRecord},
+		{"/ethanism record", quoteInvalid},
+		{"/ethanism delete", quoteDelete},
+		{"/ethanism Delete ", quoteDelete},
+		{"/ethanism delete that one", quoteInvalid},
+		{"/ethanism generate", quoteGenerate},
+		{"/ethanism card", quoteCard},
+		{"/ethanism generate please", quoteInvalid},
+		{"/ethanism's fault", quoteInvalid},
+	}
+	for _, tc := range tests {
+		t.Run(tc.text, func(t *testing.T) {
+			matches := quoteRegex.FindStringSubmatch(tc.text)
+			if matches == nil {
+				t.Fatal("didn't match")
+			}
+			if got := quoteActionOf(mapSubexpNames(matches, quoteRegex.SubexpNames())); got != tc.want {
+				t.Errorf("action is %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package meme
+
+import (
+	"time"
+
+	srv "github.com/ethanzeigler/groupme/botserver"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	callbacksReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gmbots",
+		Name:      "callbacks_total",
+		Help:      "Callbacks received, by group.",
+	}, []string{"group"})
+	hooksHandled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gmbots",
+		Name:      "hooks_handled_total",
+		Help:      "Callbacks a hook took ownership of, by hook.",
+	}, []string{"hook"})
+	hookDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "gmbots",
+		Name:      "hook_duration_seconds",
+		Help:      "Time hooks take on the callbacks they handle, by hook.",
+	}, []string{"hook"})
+	outboundPosts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gmbots",
+		Name:      "outbound_posts_total",
+		Help:      "Messages handed to GroupMe, by group.",
+	}, []string{"group"})
+	// async posts are retried by the bot server and their failures never
+	// come back here, so only synchronous posts are counted
+	outboundFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gmbots",
+		Name:      "outbound_failures_total",
+		Help:      "Synchronous posts GroupMe didn't accept, by group.",
+	}, []string{"group"})
+	outboundDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gmbots",
+		Name:      "outbound_dropped_total",
+		Help:      "Posts dropped because the outbound budget was spent, by group.",
+	}, []string{"group"})
+	rateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gmbots",
+		Name:      "rate_limited_total",
+		Help:      "Commands turned away by rate limits, by group, feature and the limit hit: user, cooldown or outbound.",
+	}, []string{"group", "feature", "reason"})
+)
+
+// Middleware that counts every callback the channel receives. Only goes
+// on the first hook so each callback is counted once
+func countCallbacks(f *feature, next handler) handler {
+	return func(callback srv.Callback, i *srv.Instance) bool {
+		callbacksReceived.WithLabelValues(callback.GroupID).Inc()
+		return next(callback, i)
+	}
+}
+
+// Records a hook handling a callback and how long it took
+func observeHook(f *feature, elapsed time.Duration) {
+	hooksHandled.WithLabelValues(f.Hook.DebugName).Inc()
+	hookDuration.WithLabelValues(f.Hook.DebugName).Observe(elapsed.Seconds())
+}
+
+// Posts a message and waits for GroupMe to take it, counting failures.
+// Returns errOverBudget if the outbound budget is spent
+func postSync(groupID string, i *srv.Instance, msg srv.Message) error {
+	if !takeOutbound(groupID) {
+		return errOverBudget
+	}
+	outboundPosts.WithLabelValues(groupID).Inc()
+	err := i.PostMessageSync(msg, 1)
+	if err != nil {
+		outboundFailures.WithLabelValues(groupID).Inc()
+	}
+	return err
+}
@@ -0,0 +1,119 @@
+package meme
+
+import (
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	srv "github.com/ethanzeigler/groupme/botserver"
+	"github.com/sirupsen/logrus"
+)
+
+// Signature of srv.BasicHook handlers. Returns true if the callback was
+// handled and shouldn't be passed to other hooks.
+type handler func(callback srv.Callback, i *srv.Instance) (cont bool)
+
+// Wraps a feature's handler with behavior shared by every hook
+type middleware func(f *feature, next handler) handler
+
+// Hooks slower than this are logged as warnings
+const slowHookThreshold = 2 * time.Second
+
+// Middleware applied to every hook the channel registers. The first
+// middleware is the outermost and sees the callback first.
+var channelMiddleware = []middleware{
+	recoverPanic,
+	logHandled,
+	timeHook,
+	filterSenders,
+	checkFeatureFlag,
+	authorize,
+	rateLimit,
+}
+
+// Wraps the feature's handler in the middleware and creates its hook
+func (f *feature) hook(chain []middleware) *srv.BasicHook {
+	h := handler(f.Hook.Handler)
+	handlerHooks[funcName(f.Hook.Handler)] = f.Hook.DebugName
+	for n := len(chain) - 1; n >= 0; n-- {
+		h = chain[n](f, h)
+	}
+	return &srv.BasicHook{DebugName: f.Hook.DebugName, Handler: h}
+}
+
+// Creates a message that will be posted by the group's bot
+func newMessage(callback srv.Callback) srv.Message {
+	return srv.Message{BotID: idMap[callback.GroupID]}
+}
+
+// Posts a text reply to the group the callback came from
+func reply(callback srv.Callback, i *srv.Instance, text string) {
+	msg := newMessage(callback)
+	msg.Text = text
+	send(callback.GroupID, i, msg)
+}
+
+// Middleware that keeps a panicking hook from taking down the bot
+func recoverPanic(f *feature, next handler) handler {
+	return func(callback srv.Callback, i *srv.Instance) (cont bool) {
+		defer func() {
+			if r := recover(); r != nil {
+				replyError(callback, i, fmt.Errorf("panic: %v", r), "Hook panicked")
+				i.Log.WithField("hook", f.Hook.DebugName).Debug(string(debug.Stack()))
+				cont = true
+			}
+		}()
+		return next(callback, i)
+	}
+}
+
+// Middleware that logs every callback a hook takes ownership of
+func logHandled(f *feature, next handler) handler {
+	return func(callback srv.Callback, i *srv.Instance) bool {
+		cont := next(callback, i)
+		if cont {
+			i.Log.WithFields(logrus.Fields{
+				"hook":   f.Hook.DebugName,
+				"group":  callback.GroupID,
+				"sender": callback.SenderID,
+				"text":   callback.Text,
+			}).Debug("Hook handled callback")
+		}
+		return cont
+	}
+}
+
+// Middleware that times the callbacks a hook handles and warns about
+// hooks that take too long
+func timeHook(f *feature, next handler) handler {
+	return func(callback srv.Callback, i *srv.Instance) bool {
+		start := time.Now()
+		cont := next(callback, i)
+		elapsed := time.Since(start)
+		if cont {
+			observeHook(f, elapsed)
+		}
+		if elapsed > slowHookThreshold {
+			i.Log.WithFields(logrus.Fields{
+				"hook":    f.Hook.DebugName,
+				"group":   callback.GroupID,
+				"elapsed": elapsed.String(),
+			}).Warning("Slow hook")
+		}
+		return cont
+	}
+}
+
+// Middleware that keeps admin only features from everyone else
+func authorize(f *feature, next handler) handler {
+	return func(callback srv.Callback, i *srv.Instance) bool {
+		if !f.AdminOnly || f.Trigger == nil || !f.Trigger.MatchString(callback.Text) {
+			return next(callback, i)
+		}
+		if !isAdmin(callback.GroupID, callback.SenderID) {
+			reply(callback, i, "Only group admins can do that")
+			return true
+		}
+		return next(callback, i)
+	}
+}
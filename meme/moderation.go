@@ -0,0 +1,90 @@
+package meme
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	srv "github.com/ethanzeigler/groupme/botserver"
+	"github.com/sirupsen/logrus"
+)
+
+// most pending quotes listed at once
+const pendingListLimit = 10
+
+var moderationRegex = regexp.MustCompile(`^(?is)/quotes\s+(?:(?P<Pending>pending)|` +
+	`(?P<Action>approve|reject)\s+#?(?P<ID>\d+)(?:\s+(?P<Reason>.+?))?)\s*$`)
+
+// Returns true if quotes recorded by the sender need a moderator's approval
+func needsApproval(callback srv.Callback) bool {
+	return groups[callback.GroupID].Moderated && !isAdmin(callback.GroupID, callback.SenderID)
+}
+
+// Lists, approves and rejects quotes waiting in a moderated group
+func moderationCommand(callback srv.Callback, i *srv.Instance) (cont bool) {
+	matches := moderationRegex.FindStringSubmatch(callback.Text)
+	if matches == nil {
+		cont = false
+		return
+	}
+	cont = true
+	captureGroups := mapSubexpNames(matches, moderationRegex.SubexpNames())
+
+	if hasGroup(captureGroups, "Pending") {
+		quotes, err := quoteDB.GetPendingQuotes(callback.GroupID, pendingListLimit)
+		if err != nil {
+			replyError(callback, i, err, "Cannot get pending quotes")
+			return
+		}
+		if len(quotes) == 0 {
+			reply(callback, i, "Nothing waiting for approval")
+			return
+		}
+		lines := []string{"Waiting for approval (/quotes approve <id> or /quotes reject <id> [reason]):"}
+		for _, quote := range quotes {
+			line := fmt.Sprintf("#%d %s: %s", *quote.ID, capitalize(*quote.Name), *quote.Quote)
+			if quote.SubmitterName != nil {
+				line += fmt.Sprintf(" (from %s)", *quote.SubmitterName)
+			}
+			lines = append(lines, line)
+		}
+		reply(callback, i, strings.Join(lines, "\n"))
+		return
+	}
+
+	id, err := strconv.ParseUint(captureGroups["ID"], 10, 64)
+	if err != nil {
+		reply(callback, i, "That isn't a quote ID")
+		return
+	}
+	reason := strings.TrimSpace(captureGroups["Reason"])
+	approve := strings.EqualFold(captureGroups["Action"], "approve")
+	if approve {
+		quote, err := quoteDB.ApproveQuote(callback.GroupID, id)
+		if err != nil {
+			replyError(callback, i, err, "Cannot approve quote")
+			return
+		}
+		reply(callback, i, fmt.Sprintf("Approved #%d: %s", id, formatQuote(quote, callback.GroupID)))
+	} else {
+		_, err := quoteDB.RejectQuote(callback.GroupID, id, reason)
+		if err != nil {
+			replyError(callback, i, err, "Cannot reject quote")
+			return
+		}
+		text := fmt.Sprintf("Rejected #%d", id)
+		if reason != "" {
+			text += ": " + reason
+		}
+		reply(callback, i, text)
+	}
+
+	i.Log.WithFields(logrus.Fields{
+		"group":    callback.GroupID,
+		"quote":    id,
+		"approved": approve,
+		"by":       callback.SenderID,
+	}).Info("Quote reviewed")
+	return
+}
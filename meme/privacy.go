@@ -0,0 +1,210 @@
+package meme
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	srv "github.com/ethanzeigler/groupme/botserver"
+	"github.com/ethanzeigler/groupme/gmbots/adapter"
+	"github.com/sirupsen/logrus"
+)
+
+// how long someone has to confirm /quotes forget me
+const forgetConfirmWindow = 2 * time.Minute
+
+var privacyRegex = regexp.MustCompile(`^(?i)/quotes\s+(?:(?P<Opt>optout|optin)\s+/?(?P<Name>\S+?)(?:ism)?|` +
+	`(?P<Claims>claims)|(?P<Review>verify|unclaim)\s+/?(?P<ReviewName>\S+?)(?:ism)?|` +
+	`forget\s+me(?P<Submissions>\s+and\s+my\s+submissions)?(?P<Confirm>\s+confirm)?)\s*$`)
+
+// A /quotes forget me waiting to be confirmed
+type forgetRequest struct {
+	submissions bool
+	expires     time.Time
+}
+
+// Unconfirmed requests by group and user ID
+var forgetRequests = struct {
+	sync.Mutex
+	pending map[string]forgetRequest
+}{pending: make(map[string]forgetRequest)}
+
+// Writes to the audit log, falling back to the bot's log if that fails
+func audit(callback srv.Callback, i *srv.Instance, action string, detail string) {
+	entry := i.Log.WithFields(logrus.Fields{
+		"group":  callback.GroupID,
+		"user":   callback.SenderID,
+		"action": action,
+		"detail": detail,
+	})
+	if err := quoteDB.WriteAudit(callback.GroupID, callback.SenderID, action, detail); err != nil {
+		entry.WithField("err", err.Error()).Error("Cannot write audit log")
+		return
+	}
+	entry.Info("Audited")
+}
+
+// Returns true if the name is plainly the sender's: their user ID, a word
+// of their display name or the whole of it without spaces
+func ownsName(callback srv.Callback, name string) bool {
+	name = strings.ToLower(name)
+	if name == strings.ToLower(callback.SenderID) {
+		return true
+	}
+	words := strings.Fields(strings.ToLower(callback.Name))
+	for _, word := range words {
+		if word == name {
+			return true
+		}
+	}
+	return len(words) > 0 && strings.Join(words, "") == name
+}
+
+// Lets admins approve or drop claims on names that didn't match the claimant
+func reviewClaims(callback srv.Callback, i *srv.Instance, captureGroups map[string]string) {
+	if !isAdmin(callback.GroupID, callback.SenderID) {
+		reply(callback, i, "Only group admins can do that")
+		return
+	}
+	if hasGroup(captureGroups, "Claims") {
+		claims, err := quoteDB.GetPendingClaims(callback.GroupID)
+		if err != nil {
+			replyError(callback, i, err, "Cannot get pending claims")
+			return
+		}
+		if len(claims) == 0 {
+			reply(callback, i, "No claims are waiting")
+			return
+		}
+		lines := []string{"Waiting for an admin (/quotes verify|unclaim <name>):"}
+		for _, claim := range claims {
+			lines = append(lines, fmt.Sprintf("%s claimed by %s", claim.Name, claim.UserName))
+		}
+		reply(callback, i, strings.Join(lines, "\n"))
+		return
+	}
+
+	name := strings.ToLower(captureGroups["ReviewName"])
+	if strings.EqualFold(captureGroups["Review"], "verify") {
+		claim, err := quoteDB.VerifyClaim(callback.GroupID, name)
+		if errors.Is(err, adapter.ErrNotFound) {
+			reply(callback, i, fmt.Sprintf("Nobody is waiting on %s", name))
+			return
+		} else if err != nil {
+			replyError(callback, i, err, "Cannot verify claim")
+			return
+		}
+		audit(callback, i, "verify claim", name+" for "+claim.UserID)
+		reply(callback, i, fmt.Sprintf("%s is now linked to %s", name, claim.UserName))
+		return
+	}
+	err := quoteDB.DeleteClaim(callback.GroupID, name)
+	if errors.Is(err, adapter.ErrNotFound) {
+		reply(callback, i, fmt.Sprintf("Nobody has claimed %s", name))
+		return
+	} else if err != nil {
+		replyError(callback, i, err, "Cannot remove claim")
+		return
+	}
+	audit(callback, i, "unclaim", name)
+	reply(callback, i, fmt.Sprintf("%s isn't linked to anyone now", name))
+}
+
+// Opts members out of being quoted and deletes their quotes
+func privacyCommand(callback srv.Callback, i *srv.Instance) (cont bool) {
+	matches := privacyRegex.FindStringSubmatch(callback.Text)
+	if matches == nil {
+		cont = false
+		return
+	}
+	cont = true
+	captureGroups := mapSubexpNames(matches, privacyRegex.SubexpNames())
+
+	if hasGroup(captureGroups, "Claims") || hasGroup(captureGroups, "Review") {
+		reviewClaims(callback, i, captureGroups)
+		return
+	}
+
+	if hasGroup(captureGroups, "Opt") {
+		name := strings.ToLower(captureGroups["Name"])
+		optOut := strings.EqualFold(captureGroups["Opt"], "optout")
+		// anyone could type anyone's name, so claims on names that aren't
+		// plainly the sender's wait for an admin
+		verified := ownsName(callback, name) || isAdmin(callback.GroupID, callback.SenderID)
+		verified, err := quoteDB.SetOptOut(callback.GroupID, callback.SenderID, callback.Name, name, optOut, verified)
+		if errors.Is(err, adapter.ErrNameTaken) {
+			reply(callback, i, fmt.Sprintf("Someone else has already claimed %s. Ask an admin for help", name))
+			return
+		} else if err != nil {
+			replyError(callback, i, err, "Cannot save opt out")
+			return
+		}
+		if !verified {
+			audit(callback, i, "claim", name)
+			reply(callback, i, fmt.Sprintf("An admin needs to confirm /%sism is you before that takes effect", name))
+			return
+		}
+		if optOut {
+			audit(callback, i, "optout", name)
+			reply(callback, i, fmt.Sprintf("Nobody can record /%sism quotes anymore. Use /quotes forget me "+
+				"to delete the ones already saved", name))
+		} else {
+			audit(callback, i, "optin", name)
+			reply(callback, i, fmt.Sprintf("/%sism quotes can be recorded again", name))
+		}
+		return
+	}
+
+	key := callback.GroupID + "/" + callback.SenderID
+	if hasGroup(captureGroups, "Confirm") {
+		forgetRequests.Lock()
+		request, ok := forgetRequests.pending[key]
+		delete(forgetRequests.pending, key)
+		forgetRequests.Unlock()
+		if !ok || time.Now().After(request.expires) {
+			reply(callback, i, "There's nothing to confirm. Start over with /quotes forget me")
+			return
+		}
+		deleted, err := quoteDB.ForgetUser(callback.GroupID, callback.SenderID, request.submissions)
+		if err != nil {
+			replyError(callback, i, err, "Cannot forget user")
+			return
+		}
+		audit(callback, i, "forget", fmt.Sprintf("deleted %d quotes, submissions: %t", deleted, request.submissions))
+		reply(callback, i, fmt.Sprintf("Deleted %d quotes", deleted))
+		return
+	}
+
+	names, err := quoteDB.GetLinkedNames(callback.GroupID, callback.SenderID)
+	if err != nil {
+		replyError(callback, i, err, "Cannot get linked names")
+		return
+	}
+	submissions := hasGroup(captureGroups, "Submissions")
+	if len(names) == 0 && !submissions {
+		reply(callback, i, "Claim the name you're quoted under first with /quotes optout <name>")
+		return
+	}
+	count, err := quoteDB.CountForgettable(callback.GroupID, callback.SenderID, submissions)
+	if err != nil {
+		replyError(callback, i, err, "Cannot count quotes to forget")
+		return
+	}
+
+	forgetRequests.Lock()
+	forgetRequests.pending[key] = forgetRequest{submissions: submissions, expires: time.Now().Add(forgetConfirmWindow)}
+	forgetRequests.Unlock()
+
+	what := "quotes of " + strings.Join(names, ", ")
+	if submissions && len(names) > 0 {
+		what += " and quotes you recorded"
+	} else if submissions {
+		what = "quotes you recorded"
+	}
+	reply(callback, i, fmt.Sprintf("This deletes %d %s for good. Send /quotes forget me confirm within %d minutes to go ahead",
+		count, what, int(forgetConfirmWindow.Minutes())))
+	return
+}
@@ -0,0 +1,145 @@
+package meme
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	srv "github.com/ethanzeigler/groupme/botserver"
+	"github.com/ethanzeigler/groupme/gmbots/adapter"
+)
+
+// most names suggested for a misspelled name
+const maxSuggestions = 3
+
+var randomQuoteRegex = regexp.MustCompile(`^(?i)/quote(?:\s+#?(?P<ID>\d+)(?:\s+(?P<Card>card)|\s+(?P<Vote>[+-]1))?)?\s*$`)
+
+func randomQuote(callback srv.Callback, i *srv.Instance) (cont bool) {
+	matches := randomQuoteRegex.FindStringSubmatch(callback.Text)
+	if matches == nil {
+		cont = false
+		return
+	}
+	cont = true
+	captureGroups := mapSubexpNames(matches, randomQuoteRegex.SubexpNames())
+
+	var quote adapter.Quote
+	var err error
+	if hasGroup(captureGroups, "ID") {
+		id, parseErr := strconv.ParseUint(captureGroups["ID"], 10, 64)
+		if parseErr != nil {
+			reply(callback, i, "That isn't a quote ID")
+			return
+		}
+		if hasGroup(captureGroups, "Vote") {
+			vote := 1
+			if captureGroups["Vote"] == "-1" {
+				vote = -1
+			}
+			voteQuote(callback, i, id, vote)
+			return
+		}
+		quote, err = quoteDB.GetQuoteByID(callback.GroupID, id)
+	} else {
+		quote, err = pickQuote("%", callback)
+	}
+	if err != nil {
+		replyError(callback, i, err, "Cannot get quote")
+		return
+	}
+	if hasGroup(captureGroups, "Card") {
+		postCard(callback, i, quote)
+		return
+	}
+	replyQuote(callback, i, quote)
+	return
+}
+
+// Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for x := 1; x <= len(ra); x++ {
+		curr[0] = x
+		for y := 1; y <= len(rb); y++ {
+			cost := 1
+			if ra[x-1] == rb[y-1] {
+				cost = 0
+			}
+			// deletion, insertion or substitution, whichever is cheapest
+			curr[y] = prev[y] + 1
+			if curr[y-1]+1 < curr[y] {
+				curr[y] = curr[y-1] + 1
+			}
+			if prev[y-1]+cost < curr[y] {
+				curr[y] = prev[y-1] + cost
+			}
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+// Finds the names in the group closest to one that has no quotes
+func suggestNames(groupID string, name string) ([]string, error) {
+	names, err := quoteDB.GetQuoteeNames(groupID)
+	if err != nil {
+		return nil, err
+	}
+	return closestNames(name, names), nil
+}
+
+// Picks the names within a few typos of name, closest first. Names are
+// looked up case-sensitively, so they're suggested the way they're stored
+// and the name that was typed is left out
+func closestNames(name string, names []string) []string {
+	lower := strings.ToLower(name)
+	// allow about one typo for every three letters
+	maxDistance := len(lower)/3 + 1
+	distances := make(map[string]int)
+	var matches []string
+	for _, candidate := range names {
+		if _, seen := distances[candidate]; seen || candidate == name {
+			continue
+		}
+		distance := editDistance(lower, strings.ToLower(candidate))
+		distances[candidate] = distance
+		if distance <= maxDistance {
+			matches = append(matches, candidate)
+		}
+	}
+	sort.Slice(matches, func(a, b int) bool {
+		if distances[matches[a]] != distances[matches[b]] {
+			return distances[matches[a]] < distances[matches[b]]
+		}
+		return matches[a] < matches[b]
+	})
+	if len(matches) > maxSuggestions {
+		matches = matches[:maxSuggestions]
+	}
+	return matches
+}
+
+// Replies to a lookup that found nothing, suggesting names that do have quotes
+func replyNotFound(callback srv.Callback, i *srv.Instance, err error, name string) {
+	if !errors.Is(err, adapter.ErrNotFound) {
+		replyError(callback, i, err, "Cannot get quote")
+		return
+	}
+	suggestions, suggestErr := suggestNames(callback.GroupID, name)
+	if suggestErr != nil || len(suggestions) == 0 {
+		replyError(callback, i, err, "Cannot get quote")
+		return
+	}
+	for n, suggestion := range suggestions {
+		suggestions[n] = "/" + suggestion + "ism"
+	}
+	reply(callback, i, fmt.Sprintf("No quotes found. Did you mean %s?", strings.Join(suggestions, " or ")))
+}
@@ -0,0 +1,65 @@
+package meme
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"ethan", "ethan", 0},
+		{"", "abc", 3},
+		{"abc", "", 3},
+		{"ethan", "etahn", 2},
+		{"ethan", "ethn", 1},
+		{"ethan", "ethans", 1},
+		{"ethan", "evan", 2},
+		{"kitten", "sitting", 3},
+		{"josé", "jose", 1},
+		{"zoë", "zoe", 1},
+	}
+	for _, tc := range tests {
+		if got := editDistance(tc.a, tc.b); got != tc.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tc.a, tc.b, got, tc.want)
+		}
+		if got := editDistance(tc.b, tc.a); got != tc.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tc.b, tc.a, got, tc.want)
+		}
+	}
+}
+
+func TestClosestNames(t *testing.T) {
+	names := []string{"ethan", "Ethan", "evan", "nathan", "bob", "bobby", "rob", "robert", "jon", "john", "joan"}
+	tests := []struct {
+		name string
+		// names with quotes, if not the shared ones
+		names []string
+		want  []string
+	}{
+		{"etan", nil, []string{"Ethan", "ethan", "evan"}},
+		{"ETHN", nil, []string{"Ethan", "ethan", "evan"}},
+		{"ethann", nil, []string{"Ethan", "ethan", "evan"}},
+		{"bobb", nil, []string{"bob", "bobby", "rob"}},
+		{"jhon", nil, []string{"jon", "joan", "john"}},
+		{"zzzzzz", nil, nil},
+		{"x", nil, nil},
+		{"ethan", []string{"Ethan", "bob"}, []string{"Ethan"}},
+		{"ETHAN", []string{"ethan", "Ethan"}, []string{"Ethan", "ethan"}},
+		{"Ethan", []string{"Ethan", "Ethan", "bob"}, nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			candidates := names
+			if tc.names != nil {
+				candidates = tc.names
+			}
+			if got := closestNames(tc.name, candidates); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("closestNames(%q) = %v, want %v", tc.name, got, tc.want)
+			}
+		})
+	}
+}
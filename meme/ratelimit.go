@@ -0,0 +1,186 @@
+package meme
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	srv "github.com/ethanzeigler/groupme/botserver"
+	"github.com/sirupsen/logrus"
+)
+
+// Per-group limits on how often commands can be used
+type RateLimits struct {
+	// Minimum time between uses of a feature in the group, by feature name
+	Cooldowns map[string]time.Duration
+	// Most commands a single user can run within UserWindow. 0 for no limit
+	UserLimit  int
+	UserWindow time.Duration
+}
+
+// Simple token bucket shared by everything the channel posts
+type tokenBucket struct {
+	sync.Mutex
+	capacity float64
+	tokens   float64
+	// tokens regained per second
+	rate float64
+	last time.Time
+}
+
+// Global outbound budget. Nil when posts aren't limited
+var outbound *tokenBucket
+
+var limiter = struct {
+	sync.Mutex
+	// last time a feature was used, by group and feature
+	lastUse map[string]time.Time
+	// recent commands, by group and user
+	userHits map[string][]time.Time
+	// users that have already been told to slow down, by group and user
+	warned map[string]bool
+}{
+	lastUse:  make(map[string]time.Time),
+	userHits: make(map[string][]time.Time),
+	warned:   make(map[string]bool),
+}
+
+// Limits how many messages the channel posts per minute across all
+// groups. 0 removes the limit.
+func SetOutboundLimit(perMinute int) {
+	if perMinute <= 0 {
+		outbound = nil
+		return
+	}
+	outbound = &tokenBucket{
+		capacity: float64(perMinute),
+		tokens:   float64(perMinute),
+		rate:     float64(perMinute) / 60,
+		last:     time.Now(),
+	}
+}
+
+// Returned for posts dropped because the outbound budget is spent
+var errOverBudget = errors.New("outbound post budget spent")
+
+// Adds the tokens regained since the bucket was last used. Must be
+// called with the bucket locked
+func (b *tokenBucket) refill() {
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+	b.last = now
+}
+
+// Takes a token from the bucket. Returns false if it's empty
+func (b *tokenBucket) take() bool {
+	b.Lock()
+	defer b.Unlock()
+	b.refill()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Returns true if the bucket has a token, without taking it
+func (b *tokenBucket) available() bool {
+	b.Lock()
+	defer b.Unlock()
+	b.refill()
+	return b.tokens >= 1
+}
+
+// Spends a post from the outbound budget. Every post goes through here,
+// whether or not it answers a command. Returns false if the post has to
+// be dropped
+func takeOutbound(groupID string) bool {
+	if outbound == nil || outbound.take() {
+		return true
+	}
+	outboundDropped.WithLabelValues(groupID).Inc()
+	return false
+}
+
+// Checks whether the sender may use the feature right now and records
+// the use if so. warn is true the first time a user is throttled so they
+// get a single reply instead of one per attempt.
+func checkRateLimit(callback srv.Callback, f *feature) (allowed bool, warn bool) {
+	limits := groups[callback.GroupID].RateLimits
+	userKey := callback.GroupID + "/" + callback.SenderID
+	featureKey := callback.GroupID + "/" + f.Name
+	now := time.Now()
+
+	limiter.Lock()
+	defer limiter.Unlock()
+
+	// drop hits that have left the window
+	hits := limiter.userHits[userKey]
+	if limits.UserLimit > 0 {
+		recent := hits[:0]
+		for _, hit := range hits {
+			if now.Sub(hit) < limits.UserWindow {
+				recent = append(recent, hit)
+			}
+		}
+		hits = recent
+		limiter.userHits[userKey] = hits
+	}
+
+	reason := ""
+	if limits.UserLimit > 0 && len(hits) >= limits.UserLimit {
+		reason = "user"
+	} else if cooldown, ok := limits.Cooldowns[f.Name]; ok && now.Sub(limiter.lastUse[featureKey]) < cooldown {
+		reason = "cooldown"
+	}
+	if reason != "" {
+		rateLimited.WithLabelValues(callback.GroupID, f.Name, reason).Inc()
+		if !limiter.warned[userKey] {
+			limiter.warned[userKey] = true
+			return false, true
+		}
+		return false, false
+	}
+
+	// the bot is over its posting budget. Telling anyone would only make it
+	// worse. The posts themselves are charged when they're sent
+	if outbound != nil && !outbound.available() {
+		rateLimited.WithLabelValues(callback.GroupID, f.Name, "outbound").Inc()
+		return false, false
+	}
+
+	if limits.UserLimit > 0 {
+		limiter.userHits[userKey] = append(hits, now)
+	}
+	limiter.lastUse[featureKey] = now
+	delete(limiter.warned, userKey)
+	return true, false
+}
+
+// Middleware that throttles features used too often. Only messages
+// matching the feature's trigger count against the limits.
+func rateLimit(f *feature, next handler) handler {
+	return func(callback srv.Callback, i *srv.Instance) bool {
+		if f.Trigger == nil || !f.Trigger.MatchString(callback.Text) {
+			return next(callback, i)
+		}
+		allowed, warn := checkRateLimit(callback, f)
+		if !allowed {
+			i.Log.WithFields(logrus.Fields{
+				"feature": f.Name,
+				"group":   callback.GroupID,
+				"sender":  callback.SenderID,
+			}).Debug("Rate limited")
+			if warn {
+				reply(callback, i, fmt.Sprintf("Slow down, %s", callback.Name))
+			}
+			// the command was meant for this hook, so don't pass it on
+			return true
+		}
+		return next(callback, i)
+	}
+}
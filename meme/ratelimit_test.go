@@ -0,0 +1,30 @@
+package meme
+
+import "testing"
+
+func TestTakeOutbound(t *testing.T) {
+	defer SetOutboundLimit(0)
+
+	SetOutboundLimit(0)
+	for n := 0; n < 100; n++ {
+		if !takeOutbound("1") {
+			t.Fatal("post dropped without a limit")
+		}
+	}
+
+	SetOutboundLimit(3)
+	for n := 0; n < 3; n++ {
+		if !outbound.available() {
+			t.Fatalf("no budget left after %d posts, want 3", n)
+		}
+		if !takeOutbound("1") {
+			t.Fatalf("post %d dropped, want 3 allowed", n+1)
+		}
+	}
+	if outbound.available() {
+		t.Error("budget left after it was spent")
+	}
+	if takeOutbound("1") {
+		t.Error("post allowed over the budget")
+	}
+}
@@ -0,0 +1,41 @@
+package meme
+
+import (
+	"strings"
+
+	srv "github.com/ethanzeigler/groupme/botserver"
+)
+
+// Returns false for callbacks hooks shouldn't react to: posts made by
+// this bot and by other bots that aren't allowlisted for the group.
+// This keeps a caption that happens to match a trigger from setting
+// off a feedback loop.
+func senderAllowed(callback srv.Callback) bool {
+	group := groups[callback.GroupID]
+	if group.BotUserID != "" &&
+		(callback.SenderID == group.BotUserID || callback.UserID == group.BotUserID) {
+		return false
+	}
+	if group.BotID != "" && callback.SenderID == group.BotID {
+		return false
+	}
+	if strings.EqualFold(callback.SenderType, "bot") {
+		for _, bot := range group.AllowedBots {
+			if bot == callback.SenderID || bot == callback.UserID {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Middleware that drops callbacks from senders the bot ignores
+func filterSenders(f *feature, next handler) handler {
+	return func(callback srv.Callback, i *srv.Instance) bool {
+		if !senderAllowed(callback) {
+			return false
+		}
+		return next(callback, i)
+	}
+}
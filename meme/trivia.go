@@ -0,0 +1,234 @@
+package meme
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	srv "github.com/ethanzeigler/groupme/botserver"
+	"github.com/ethanzeigler/groupme/gmbots/adapter"
+	"github.com/ethanzeigler/groupme/gmbots/report"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// how long guesses are taken when a group doesn't configure it
+	defaultTriviaWindow = time.Minute
+	// points for the first right answer and for any after it
+	triviaFirstPoints = 2
+	triviaPoints      = 1
+)
+
+var triviaRegex = regexp.MustCompile(`^(?i)/quotes\s+game(?:\s+(?P<Subcommand>stop|scores))?\s*$`)
+var guessRegex = regexp.MustCompile(`^(?i)/guess\s+@?(?P<Name>.+?)(?:ism)?\s*$`)
+
+// A round of "who said it?" waiting on guesses
+type triviaGame struct {
+	// speaker of the quote
+	answer string
+	// guesses by user ID
+	guesses map[string]string
+	// user IDs in the order they guessed
+	order []string
+	// names of the guessers by user ID
+	names map[string]string
+	timer *time.Timer
+}
+
+// Rounds in progress by group ID
+var triviaGames = struct {
+	sync.Mutex
+	groups map[string]*triviaGame
+}{groups: make(map[string]*triviaGame)}
+
+func triviaCommand(callback srv.Callback, i *srv.Instance) (cont bool) {
+	if matches := guessRegex.FindStringSubmatch(callback.Text); matches != nil {
+		return triviaGuess(callback, i, mapSubexpNames(matches, guessRegex.SubexpNames())["Name"])
+	}
+	matches := triviaRegex.FindStringSubmatch(callback.Text)
+	if matches == nil {
+		cont = false
+		return
+	}
+	cont = true
+
+	switch strings.ToLower(mapSubexpNames(matches, triviaRegex.SubexpNames())["Subcommand"]) {
+	case "stop":
+		triviaGames.Lock()
+		game, ok := triviaGames.groups[callback.GroupID]
+		if ok {
+			game.timer.Stop()
+			delete(triviaGames.groups, callback.GroupID)
+		}
+		triviaGames.Unlock()
+		if ok {
+			reply(callback, i, fmt.Sprintf("Game stopped. It was %s", game.answer))
+		} else {
+			reply(callback, i, "There's no game going")
+		}
+	case "scores":
+		triviaScores(callback, i)
+	default:
+		triviaStart(callback, i)
+	}
+	return
+}
+
+// Returns true if the group has a round going
+func triviaRunning(groupID string) bool {
+	triviaGames.Lock()
+	defer triviaGames.Unlock()
+	_, ok := triviaGames.groups[groupID]
+	return ok
+}
+
+// Posts a quote without its speaker and starts taking guesses
+func triviaStart(callback srv.Callback, i *srv.Instance) {
+	if triviaRunning(callback.GroupID) {
+		reply(callback, i, "There's already a game going. /guess <name>")
+		return
+	}
+
+	// the quote is picked without holding the lock so a slow database
+	// doesn't hold up guesses in every group
+	quote, err := quoteDB.GetTriviaQuote(callback.GroupID)
+	if err != nil {
+		if errors.Is(err, adapter.ErrNotFound) {
+			reply(callback, i, "There aren't enough quotes to play yet")
+		} else {
+			replyError(callback, i, err, "Cannot get trivia quote")
+		}
+		return
+	}
+
+	window := groups[callback.GroupID].TriviaWindow
+	if window <= 0 {
+		window = defaultTriviaWindow
+	}
+	groupID := callback.GroupID
+	game := &triviaGame{
+		answer:  *quote.Name,
+		guesses: make(map[string]string),
+		names:   make(map[string]string),
+	}
+
+	triviaGames.Lock()
+	if _, ok := triviaGames.groups[groupID]; ok {
+		// someone else started one while the quote was loading
+		triviaGames.Unlock()
+		reply(callback, i, "There's already a game going. /guess <name>")
+		return
+	}
+	game.timer = time.AfterFunc(window, func() {
+		// runs on its own goroutine, where a panic would take down the bot
+		defer recoverReveal(groupID, i)
+		triviaReveal(groupID, game, i)
+	})
+	triviaGames.groups[groupID] = game
+	triviaGames.Unlock()
+
+	reply(callback, i, fmt.Sprintf("Who said it?\n\"%s\"\n/guess <name> in the next %s",
+		*quote.Quote, window.String()))
+}
+
+// Records a guess. Only the first guess of each player counts
+func triviaGuess(callback srv.Callback, i *srv.Instance, name string) bool {
+	triviaGames.Lock()
+	defer triviaGames.Unlock()
+	game, ok := triviaGames.groups[callback.GroupID]
+	if !ok {
+		// not a guess for us
+		return false
+	}
+	if _, guessed := game.guesses[callback.SenderID]; guessed {
+		return true
+	}
+	game.guesses[callback.SenderID] = strings.TrimSpace(name)
+	game.names[callback.SenderID] = callback.Name
+	game.order = append(game.order, callback.SenderID)
+	return true
+}
+
+// Logs and reports a panic while revealing an answer
+func recoverReveal(groupID string, i *srv.Instance) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	i.Log.WithFields(logrus.Fields{
+		"group": groupID,
+		"panic": fmt.Sprint(r),
+	}).Error("Trivia reveal panicked")
+	i.Log.Debug(string(debug.Stack()))
+	if reporter != nil {
+		reporter.Report(report.Incident{
+			Message: "Trivia reveal panicked",
+			Err:     fmt.Sprint(r),
+			Hook:    "Quote Trivia",
+			Where:   "meme.triviaReveal",
+			GroupID: groupID,
+			Stack:   string(debug.Stack()),
+		})
+	}
+}
+
+// Ends the round, awards points and posts the answer
+func triviaReveal(groupID string, game *triviaGame, i *srv.Instance) {
+	triviaGames.Lock()
+	if triviaGames.groups[groupID] != game {
+		// stopped before time ran out
+		triviaGames.Unlock()
+		return
+	}
+	delete(triviaGames.groups, groupID)
+	triviaGames.Unlock()
+
+	var winners []string
+	for _, userID := range game.order {
+		if !strings.EqualFold(game.guesses[userID], game.answer) {
+			continue
+		}
+		points := triviaPoints
+		if len(winners) == 0 {
+			points = triviaFirstPoints
+		}
+		err := quoteDB.AddTriviaPoints(groupID, userID, game.names[userID], points)
+		if err != nil {
+			i.Log.WithFields(logrus.Fields{
+				"err":   err.Error(),
+				"group": groupID,
+				"user":  userID,
+			}).Error("Cannot save trivia points")
+		}
+		winners = append(winners, game.names[userID])
+	}
+
+	msg := srv.Message{BotID: idMap[groupID]}
+	if len(winners) == 0 {
+		msg.Text = fmt.Sprintf("Time's up! It was %s. Nobody got it", game.answer)
+	} else {
+		msg.Text = fmt.Sprintf("Time's up! It was %s. Points to %s", game.answer, strings.Join(winners, ", "))
+	}
+	send(groupID, i, msg)
+}
+
+func triviaScores(callback srv.Callback, i *srv.Instance) {
+	scores, err := quoteDB.GetTriviaScores(callback.GroupID, 10)
+	if err != nil {
+		replyError(callback, i, err, "Cannot load trivia scores")
+		return
+	}
+	if len(scores) == 0 {
+		reply(callback, i, "Nobody has scored yet. Start a game with /quotes game")
+		return
+	}
+	var sb strings.Builder
+	for n, s := range scores {
+		sb.WriteString(fmt.Sprintf("%d. %s - %d\n", n+1, s.Name, s.Points))
+	}
+	reply(callback, i, sb.String())
+}
@@ -0,0 +1,180 @@
+package meme
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	srv "github.com/ethanzeigler/groupme/botserver"
+	"github.com/ethanzeigler/groupme/gmbots/adapter"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// quotes shown by /quotes top
+	topQuotesLimit = 10
+	// how long a posted quote waits for its message to come back
+	postedQuoteTimeout = 2 * time.Minute
+)
+
+var topQuotesRegex = regexp.MustCompile(`^(?i)/quotes\s+top\s*$`)
+
+// Tells the bot which quotes have been liked on GroupMe.
+// adapter.GroupMeAPI in production
+type LikeSource interface {
+	GetLikedMessages(groupID string, period string) ([]adapter.LikedMessage, error)
+}
+
+// A quote the bot posted whose message ID isn't known yet
+type postedQuote struct {
+	quoteID uint64
+	posted  time.Time
+}
+
+// Quotes waiting for the bot's own post to come back as a callback, by
+// group and text. That callback is the only place the message ID shows up
+var postedQuotes = struct {
+	sync.Mutex
+	pending map[string]postedQuote
+}{pending: make(map[string]postedQuote)}
+
+func postedKey(groupID string, text string) string {
+	return groupID + "/" + strings.TrimSpace(text)
+}
+
+// Posts a quote and remembers it so likes on the post count towards it
+func replyQuote(callback srv.Callback, i *srv.Instance, quote adapter.Quote) {
+	text := formatQuote(quote, callback.GroupID)
+	if quote.ID != nil {
+		postedQuotes.Lock()
+		for key, posted := range postedQuotes.pending {
+			if time.Since(posted.posted) > postedQuoteTimeout {
+				delete(postedQuotes.pending, key)
+			}
+		}
+		// the post comes back the way the filter left it
+		key := postedKey(callback.GroupID, outgoingText(callback.GroupID, nil, text))
+		postedQuotes.pending[key] = postedQuote{quoteID: *quote.ID, posted: time.Now()}
+		postedQuotes.Unlock()
+	}
+	reply(callback, i, text)
+}
+
+// Picks a random quote, favoring high scores in groups that want that
+func pickQuote(name string, callback srv.Callback) (adapter.Quote, error) {
+	if !groups[callback.GroupID].WeightedRandom {
+		if name == "%" {
+			return quoteDB.GetRandomQuote(callback)
+		}
+		return quoteDB.GetUserQuote(name, callback)
+	}
+	quotes, err := quoteDB.GetQuotes(name, callback, 1, adapter.WeightedRandomSort)
+	if err != nil {
+		return adapter.Quote{}, err
+	}
+	return quotes[0], nil
+}
+
+// Matches the bot's own posts of quotes to the quotes they were. Runs
+// before sender filtering, which would drop the bot's posts
+func quoteMessageHook(callback srv.Callback, i *srv.Instance) (cont bool) {
+	if callback.ID == "" || callback.SenderID != groups[callback.GroupID].BotID {
+		cont = false
+		return
+	}
+	key := postedKey(callback.GroupID, callback.Text)
+	postedQuotes.Lock()
+	posted, ok := postedQuotes.pending[key]
+	delete(postedQuotes.pending, key)
+	postedQuotes.Unlock()
+	if !ok {
+		cont = false
+		return
+	}
+	cont = true
+	if err := quoteDB.RecordQuoteMessage(callback.GroupID, callback.ID, posted.quoteID); err != nil {
+		i.Log.WithFields(logrus.Fields{
+			"err":     err.Error(),
+			"group":   callback.GroupID,
+			"message": callback.ID,
+		}).Error("Cannot record quote message")
+	}
+	return
+}
+
+// Sets a member's vote on a quote and tells them its new score
+func voteQuote(callback srv.Callback, i *srv.Instance, id uint64, vote int) {
+	score, err := quoteDB.VoteQuote(callback.GroupID, id, callback.SenderID, vote)
+	if err != nil {
+		replyError(callback, i, err, "Cannot vote on quote")
+		return
+	}
+	reply(callback, i, fmt.Sprintf("#%d is at %+d", id, score))
+}
+
+// Shows the group's hall of fame
+func topQuotesCommand(callback srv.Callback, i *srv.Instance) (cont bool) {
+	if !topQuotesRegex.MatchString(callback.Text) {
+		cont = false
+		return
+	}
+	cont = true
+	quotes, err := quoteDB.GetTopQuotes(callback.GroupID, topQuotesLimit)
+	if err != nil {
+		replyError(callback, i, err, "Cannot get top quotes")
+		return
+	}
+	reply(callback, i, topQuotesText(quotes, callback.GroupID))
+	return
+}
+
+// Lists the top quotes, cutting each line down so the whole list fits
+// in one message
+func topQuotesText(quotes []adapter.ScoredQuote, groupID string) string {
+	const header = "Hall of fame:"
+	lines := []string{header}
+	if len(quotes) == 0 {
+		return header
+	}
+	// every line also takes a newline
+	lineLength := (maxFormattedLength-len(header))/len(quotes) - 1
+	for n, quote := range quotes {
+		lines = append(lines, truncate(fmt.Sprintf("%d. (%+d) #%d %s", n+1, quote.Score, *quote.ID,
+			formatQuote(quote.Quote, groupID)), lineLength))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Copies like counts from GroupMe every interval. Only the last week of
+// likes is looked at, which covers quotes while they're still being liked
+func StartLikeSync(source LikeSource, interval time.Duration, log *logrus.Logger) {
+	go func() {
+		for range time.Tick(interval) {
+			for groupID := range groups {
+				syncLikes(source, groupID, log)
+			}
+		}
+	}()
+}
+
+func syncLikes(source LikeSource, groupID string, log *logrus.Logger) {
+	messages, err := source.GetLikedMessages(groupID, "week")
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"err":   err.Error(),
+			"group": groupID,
+		}).Warning("Cannot get liked messages")
+		return
+	}
+	for _, message := range messages {
+		if err := quoteDB.SetMessageLikes(groupID, message.ID, message.Likes); err != nil {
+			log.WithFields(logrus.Fields{
+				"err":     err.Error(),
+				"group":   groupID,
+				"message": message.ID,
+			}).Error("Cannot save likes")
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package meme
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ethanzeigler/groupme/gmbots/adapter"
+)
+
+func TestTopQuotesText(t *testing.T) {
+	formatCache.Lock()
+	formatCache.templates["top test"] = defaultTemplate
+	formatCache.Unlock()
+	defer func() {
+		formatCache.Lock()
+		delete(formatCache.templates, "top test")
+		formatCache.Unlock()
+	}()
+
+	scored := func(count int, length int) []adapter.ScoredQuote {
+		var quotes []adapter.ScoredQuote
+		for n := 0; n < count; n++ {
+			quote := sampleAdapterQuote()
+			id := uint64(n + 1)
+			text := strings.Repeat("ü", length/2)
+			quote.ID, quote.Quote = &id, &text
+			quotes = append(quotes, adapter.ScoredQuote{Quote: quote, Score: count - n})
+		}
+		return quotes
+	}
+	tests := []struct {
+		name   string
+		quotes []adapter.ScoredQuote
+		// whether the quotes have to be cut down
+		cut bool
+	}{
+		{"none", nil, false},
+		{"short", scored(3, 20), false},
+		{"one long", scored(1, 900), false},
+		{"ten long", scored(topQuotesLimit, 500), true},
+		{"one too long for a message", scored(1, 2000), true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			text := topQuotesText(tc.quotes, "top test")
+			if len(text) > maxFormattedLength {
+				t.Errorf("list is %d bytes, want at most %d", len(text), maxFormattedLength)
+			}
+			lines := strings.Split(text, "\n")
+			if len(lines) != len(tc.quotes)+1 {
+				t.Errorf("list has %d lines, want a header and %d quotes", len(lines), len(tc.quotes))
+			}
+			if cut := strings.Contains(text, "…"); cut != tc.cut {
+				t.Errorf("cut is %v, want %v", cut, tc.cut)
+			}
+		})
+	}
+}
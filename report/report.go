@@ -0,0 +1,159 @@
+// Package report gets failures to the developer. Each failure becomes an
+// incident with a short ID users can quote, and repeats of the same
+// failure are grouped by fingerprint so the developer hears about it once.
+package report
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// How long repeats of a failure are folded into the first report
+const DefaultDedupeWindow = time.Hour
+
+// A failure and what was going on when it happened
+type Incident struct {
+	// short ID shown to users
+	ID          string    `json:"id"`
+	Fingerprint string    `json:"fingerprint"`
+	Time        time.Time `json:"time"`
+	// what was being done, like "Cannot record quote"
+	Message string `json:"message"`
+	Err     string `json:"err"`
+	// DebugName of the hook that failed. Empty if it's not known
+	Hook string `json:"hook,omitempty"`
+	// function and line the failure was reported from
+	Where    string `json:"where"`
+	GroupID  string `json:"group_id"`
+	SenderID string `json:"sender_id"`
+	Text     string `json:"text"`
+	Stack    string `json:"stack"`
+	// times the failure happened. For a repeat, how many came after the
+	// first report
+	Count int `json:"count"`
+	// sent at the end of the window for the repeats of a failure
+	Repeat bool `json:"repeat,omitempty"`
+}
+
+// Somewhere incidents are sent
+type Sink interface {
+	Send(incident Incident) error
+}
+
+// A failure reported within the window
+type seen struct {
+	id string
+	// repeats since it was reported, and the latest of them
+	repeats int
+	last    Incident
+}
+
+// Reports incidents to sinks, once per failure per window
+type Reporter struct {
+	sinks  []Sink
+	window time.Duration
+	log    *logrus.Logger
+
+	mu sync.Mutex
+	// failures reported within the window by fingerprint. Each is removed
+	// when its window ends
+	recent map[string]*seen
+}
+
+// Creates a reporter sending to the sinks. A window of 0 uses DefaultDedupeWindow
+func NewReporter(window time.Duration, log *logrus.Logger, sinks ...Sink) *Reporter {
+	if window <= 0 {
+		window = DefaultDedupeWindow
+	}
+	return &Reporter{sinks: sinks, window: window, log: log, recent: make(map[string]*seen)}
+}
+
+// numbers that change between otherwise identical failures, like IDs and ports
+var volatile = regexp.MustCompile(`\d+`)
+
+// Gets what makes failures the same: where they happened and how,
+// without the details that change every time
+func fingerprint(incident Incident) string {
+	hash := sha1.New()
+	for _, part := range []string{incident.Hook, incident.Where, incident.Message,
+		volatile.ReplaceAllString(incident.Err, "#")} {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:16]
+}
+
+// Creates a short incident ID, like "7F3A9C"
+func newIncidentID() string {
+	b := make([]byte, 3)
+	if _, err := rand.Read(b); err != nil {
+		// an ID that's the same as another's is better than none
+		return fmt.Sprintf("%06X", time.Now().UnixNano()&0xFFFFFF)
+	}
+	return fmt.Sprintf("%X", b)
+}
+
+// Records an incident and returns the ID users can quote. Repeats of a
+// failure reported within the window get the same ID and aren't sent
+// again. When the window ends, the latest repeat is sent with how many
+// there were
+func (r *Reporter) Report(incident Incident) string {
+	incident.Time = time.Now()
+	if incident.Fingerprint == "" {
+		incident.Fingerprint = fingerprint(incident)
+	}
+
+	r.mu.Lock()
+	if s, ok := r.recent[incident.Fingerprint]; ok {
+		s.repeats++
+		s.last = incident
+		r.mu.Unlock()
+		return s.id
+	}
+	incident.Count = 1
+	incident.ID = newIncidentID()
+	r.recent[incident.Fingerprint] = &seen{id: incident.ID}
+	fingerprint := incident.Fingerprint
+	time.AfterFunc(r.window, func() { r.flush(fingerprint) })
+	r.mu.Unlock()
+
+	// sinks may be slow, and whoever hit the failure shouldn't wait on them
+	go r.send(incident)
+	return incident.ID
+}
+
+// Ends the window of a failure, sending its repeats if there were any.
+// The next time it happens it's reported as new
+func (r *Reporter) flush(fingerprint string) {
+	r.mu.Lock()
+	s, ok := r.recent[fingerprint]
+	delete(r.recent, fingerprint)
+	r.mu.Unlock()
+	if !ok || s.repeats == 0 {
+		return
+	}
+	incident := s.last
+	incident.ID = s.id
+	incident.Count = s.repeats
+	incident.Repeat = true
+	r.send(incident)
+}
+
+func (r *Reporter) send(incident Incident) {
+	for _, sink := range r.sinks {
+		if err := sink.Send(incident); err != nil {
+			r.log.WithFields(logrus.Fields{
+				"err":      err.Error(),
+				"incident": incident.ID,
+				"sink":     fmt.Sprintf("%T", sink),
+			}).Error("Cannot send error report")
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package report
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Hands sent incidents to the test
+type chanSink chan Incident
+
+func (c chanSink) Send(incident Incident) error {
+	c <- incident
+	return nil
+}
+
+// Returns the next incident sent, failing if none comes in time
+func (c chanSink) next(t *testing.T) Incident {
+	t.Helper()
+	select {
+	case incident := <-c:
+		return incident
+	case <-time.After(2 * time.Second):
+		t.Fatal("no incident was sent")
+		return Incident{}
+	}
+}
+
+// Fails if an incident is sent within the wait
+func (c chanSink) none(t *testing.T, wait time.Duration) {
+	t.Helper()
+	select {
+	case incident := <-c:
+		t.Fatalf("unexpected incident %+v", incident)
+	case <-time.After(wait):
+	}
+}
+
+func quietLogger() *logrus.Logger {
+	log := logrus.New()
+	log.Out = io.Discard
+	return log
+}
+
+func TestFingerprint(t *testing.T) {
+	base := Incident{Hook: "quote", Where: "meme.quote:10", Message: "Cannot get quotes", Err: "dial tcp 10.0.0.1:5432: timeout"}
+	tests := []struct {
+		name   string
+		change func(*Incident)
+		same   bool
+	}{
+		{"identical", func(*Incident) {}, true},
+		{"numbers in error", func(i *Incident) { i.Err = "dial tcp 10.0.0.2:6543: timeout" }, true},
+		{"user details", func(i *Incident) { i.GroupID, i.SenderID, i.Text = "1", "2", "/quote bob" }, true},
+		{"different error", func(i *Incident) { i.Err = "connection refused" }, false},
+		{"different hook", func(i *Incident) { i.Hook = "trivia" }, false},
+		{"different place", func(i *Incident) { i.Where = "meme.quote:20" }, false},
+		{"different message", func(i *Incident) { i.Message = "Cannot save quote" }, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			other := base
+			tc.change(&other)
+			if same := fingerprint(base) == fingerprint(other); same != tc.same {
+				t.Errorf("same fingerprint is %v, want %v", same, tc.same)
+			}
+		})
+	}
+}
+
+func TestReportDedupe(t *testing.T) {
+	sink := make(chanSink, 10)
+	window := 100 * time.Millisecond
+	r := NewReporter(window, quietLogger(), sink)
+	incident := Incident{Message: "Cannot get quotes", Err: "timeout"}
+
+	id := r.Report(incident)
+	first := sink.next(t)
+	if first.ID != id || first.Count != 1 || first.Repeat {
+		t.Errorf("first report is %+v, want ID %s and count 1", first, id)
+	}
+	for n := 0; n < 3; n++ {
+		if repeat := r.Report(incident); repeat != id {
+			t.Errorf("repeat %d got ID %s, want %s", n, repeat, id)
+		}
+	}
+	other := r.Report(Incident{Message: "Cannot save quote", Err: "timeout"})
+	if other == id {
+		t.Error("a different failure got the same ID")
+	}
+	if sent := sink.next(t); sent.ID != other {
+		t.Errorf("sent %s, want the different failure %s", sent.ID, other)
+	}
+
+	// the repeats are flushed when the window ends, without the failure
+	// happening again
+	flushed := sink.next(t)
+	if flushed.ID != id || flushed.Count != 3 || !flushed.Repeat {
+		t.Errorf("flushed %+v, want ID %s with 3 repeats", flushed, id)
+	}
+	sink.none(t, window)
+
+	r.mu.Lock()
+	left := len(r.recent)
+	r.mu.Unlock()
+	if left != 0 {
+		t.Errorf("%d failures are still kept after their windows", left)
+	}
+
+	if again := r.Report(incident); again == id {
+		t.Error("a failure after its window kept the old ID")
+	}
+	if sent := sink.next(t); sent.Count != 1 || sent.Repeat {
+		t.Errorf("report after the window is %+v, want a new report", sent)
+	}
+}
+
+func TestReportNoRepeats(t *testing.T) {
+	sink := make(chanSink, 10)
+	window := 50 * time.Millisecond
+	r := NewReporter(window, quietLogger(), sink)
+	r.Report(Incident{Message: "once"})
+	sink.next(t)
+	// nothing more is sent for a failure that didn't come back
+	sink.none(t, 3*window)
+}
+
+// Fails every time
+type failingSink struct {
+	mu    sync.Mutex
+	tries int
+}
+
+func (f *failingSink) Send(Incident) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tries++
+	return errors.New("unreachable")
+}
+
+func TestReportSinkFailure(t *testing.T) {
+	failing := &failingSink{}
+	sink := make(chanSink, 10)
+	r := NewReporter(time.Minute, quietLogger(), failing, sink)
+	r.Report(Incident{Message: "broken"})
+	// a failing sink doesn't keep the others from getting it
+	sink.next(t)
+	failing.mu.Lock()
+	defer failing.mu.Unlock()
+	if failing.tries != 1 {
+		t.Errorf("failing sink was tried %d times, want 1", failing.tries)
+	}
+}
@@ -0,0 +1,122 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ethanzeigler/groupme/gmbots/adapter"
+)
+
+const (
+	// GroupMe's limit on message length
+	maxDirectMessageLength = 1000
+	// bytes of the error and the message that hit it put in a DM
+	maxDirectMessageField = 300
+)
+
+// Appends incidents to a file as JSON lines. Each keeps the raw text and
+// sender ID of the message that hit the failure, which /quotes forget me
+// doesn't remove, so the file should be cleared out like any other log
+type FileSink struct {
+	Path string
+	mu   sync.Mutex
+}
+
+// Creates a sink appending to the file at path
+func NewFileSink(path string) *FileSink {
+	return &FileSink{Path: path}
+}
+
+func (f *FileSink) Send(incident Incident) error {
+	line, err := json.Marshal(incident)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file, err := os.OpenFile(f.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// POSTs incidents as JSON to a URL. Like FileSink, they include the raw
+// text and sender ID of the message that hit the failure, and whatever
+// keeps them there is out of reach of /quotes forget me
+type WebhookSink struct {
+	URL    string
+	client *http.Client
+}
+
+// Creates a sink posting to the URL
+func NewWebhookSink(url string) *WebhookSink {
+	return &WebhookSink{URL: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (w *WebhookSink) Send(incident Incident) error {
+	body, err := json.Marshal(incident)
+	if err != nil {
+		return err
+	}
+	resp, err := w.client.Post(w.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return nil
+}
+
+// DMs a summary of each incident to the developer. The stack is left
+// out, so pair it with another sink to keep those
+type DirectMessageSink struct {
+	Messenger adapter.DirectMessenger
+	// user the summaries are sent to
+	UserID string
+}
+
+// Creates a sink messaging the user
+func NewDirectMessageSink(dm adapter.DirectMessenger, userID string) *DirectMessageSink {
+	return &DirectMessageSink{Messenger: dm, UserID: userID}
+}
+
+func (d *DirectMessageSink) Send(incident Incident) error {
+	text := fmt.Sprintf("Incident %s", incident.ID)
+	if incident.Hook != "" {
+		text += " in " + incident.Hook
+	}
+	text += fmt.Sprintf("\n%s: %s\nGroup %s: %q", incident.Message, truncate(incident.Err, maxDirectMessageField),
+		incident.GroupID, truncate(incident.Text, maxDirectMessageField))
+	if incident.Repeat {
+		text += fmt.Sprintf("\nHappened %d more times since it was reported", incident.Count)
+	}
+	// quoting can still blow the text up, so the whole thing is cut down too
+	return d.Messenger.SendDirectMessage(d.UserID, truncate(text, maxDirectMessageLength))
+}
+
+// Cuts text down to at most n bytes without splitting a character, marking
+// where it was cut
+func truncate(text string, n int) string {
+	if len(text) <= n {
+		return text
+	}
+	const ellipsis = "…"
+	cut := n - len(ellipsis)
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	return text[:cut] + ellipsis
+}
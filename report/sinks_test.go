@@ -0,0 +1,57 @@
+package report
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// Keeps the DMs it's asked to send
+type fakeMessenger struct {
+	sent []string
+}
+
+func (f *fakeMessenger) SendDirectMessage(userID string, text string) error {
+	f.sent = append(f.sent, text)
+	return nil
+}
+
+func TestDirectMessageSinkFitsMessage(t *testing.T) {
+	tests := []struct {
+		name     string
+		incident Incident
+		// text the DM should still have
+		has []string
+	}{
+		{"short", Incident{ID: "7F3A9C", Hook: "quote", Message: "Cannot get quotes", Err: "timeout", GroupID: "1234", Text: "/quote bob"},
+			[]string{"Incident 7F3A9C in quote", "Cannot get quotes: timeout", `Group 1234: "/quote bob"`}},
+		{"long text", Incident{ID: "7F3A9C", Message: "Cannot record quote", Err: "timeout", GroupID: "1234",
+			Text: "/bobism record " + strings.Repeat("la ", 1000)}, []string{"Cannot record quote: timeout", "Group 1234"}},
+		{"long error", Incident{ID: "7F3A9C", Message: "Cannot record quote", Err: strings.Repeat("pq: syntax error ", 200),
+			GroupID: "1234", Text: "/quote bob"}, []string{`Group 1234: "/quote bob"`}},
+		{"multibyte", Incident{ID: "7F3A9C", Message: "Cannot record quote", Err: strings.Repeat("é", 1000),
+			GroupID: "1234", Text: strings.Repeat("😂", 1000)}, []string{"Incident 7F3A9C"}},
+		{"escapes", Incident{ID: "7F3A9C", Message: "Cannot record quote", Err: "timeout",
+			GroupID: "1234", Text: strings.Repeat("\x00", 1000), Repeat: true, Count: 3}, []string{"Incident 7F3A9C"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dm := &fakeMessenger{}
+			if err := NewDirectMessageSink(dm, "42").Send(tc.incident); err != nil {
+				t.Fatal(err)
+			}
+			text := dm.sent[0]
+			if len(text) > maxDirectMessageLength {
+				t.Errorf("DM is %d bytes, over the %d limit", len(text), maxDirectMessageLength)
+			}
+			if !utf8.ValidString(text) {
+				t.Errorf("DM was cut in the middle of a character: %q", text)
+			}
+			for _, has := range tc.has {
+				if !strings.Contains(text, has) {
+					t.Errorf("DM doesn't have %q:\n%s", has, text)
+				}
+			}
+		})
+	}
+}